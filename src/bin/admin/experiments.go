@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/experiments"
+	"api.lnlink.net/src/pkg/services/pipeline"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func lookupGroup(id string) *experiments.MultiExperiment {
+	if id == "" {
+		fail("pass -id")
+	}
+
+	groupID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		fail("invalid experiment group id %s", id)
+	}
+
+	group, err := experiments.GetExperimentByID(groupID)
+	if err != nil {
+		fail("no experiment group with id %s", id)
+	}
+	return group
+}
+
+func listExperiments(args []string) {
+	set := flag.NewFlagSet("experiments list", flag.ContinueOnError)
+	email := set.String("email", "", "only groups submitted by this user")
+	status := set.String("status", "", "only groups with an image in this status, e.g. FAILED")
+	limit := set.Int("limit", 50, "maximum number of groups")
+	parseFlags(set, args)
+
+	var userID *primitive.ObjectID
+	if *email != "" {
+		userID = &lookupUser(*email, "").ID
+	}
+
+	groups, err := experiments.ListGroups(userID, experiments.ExperimentStatus(*status), *limit)
+	if err != nil {
+		fail("can't list experiment groups: %v", err)
+	}
+	printJSON(map[string]any{"experiments": groups})
+}
+
+func showExperiment(args []string) {
+	set := flag.NewFlagSet("experiments show", flag.ContinueOnError)
+	id := set.String("id", "", "id of the experiment group")
+	parseFlags(set, args)
+
+	printJSON(map[string]any{"experiment": lookupGroup(*id)})
+}
+
+// resubmits failed images with a fresh retry budget, the group gets a new
+// download link once everything completed
+func requeueExperiments(args []string) {
+	set := flag.NewFlagSet("experiments requeue", flag.ContinueOnError)
+	id := set.String("id", "", "id of the experiment group")
+	fileID := set.String("file", "", "only requeue the image with this file id")
+	parseFlags(set, args)
+
+	if !global.CONFIG.Inference.Enabled() {
+		fail("inference is not configured")
+	}
+
+	group := lookupGroup(*id)
+
+	requeued := []string{}
+	for _, exp := range group.Experiments {
+		if exp.Status != experiments.ExperimentFailed || (*fileID != "" && exp.FileID != *fileID) {
+			continue
+		}
+
+		ok, err := pipeline.Requeue(group.ID, exp.FileID)
+		if err == pipeline.ErrClaimed {
+			fail("%s is being processed right now, try again", exp.FileID)
+		}
+		if err != nil {
+			fail("can't requeue %s: %v", exp.FileID, err)
+		}
+		if ok {
+			requeued = append(requeued, exp.FileID)
+		}
+	}
+
+	if len(requeued) == 0 {
+		fail("no failed images to requeue")
+	}
+
+	printJSON(map[string]any{"id": group.ID, "requeued": requeued})
+}
+
+// rebuilds the results archive and presigns a new link, e.g. after the old one expired
+func regenerateDownloadLink(args []string) {
+	set := flag.NewFlagSet("experiments link", flag.ContinueOnError)
+	id := set.String("id", "", "id of the experiment group")
+	parseFlags(set, args)
+
+	if !global.CONFIG.Storage.Enabled() {
+		fail("storage is not configured")
+	}
+
+	group := lookupGroup(*id)
+
+	downloadURL, err := experiments.GenerateDownloadLink(group.ID)
+	if err != nil {
+		fail("can't generate download link: %v", err)
+	}
+	if err := group.SetDownloadURL(downloadURL); err != nil {
+		fail("can't store download link: %v", err)
+	}
+	printJSON(map[string]any{"id": group.ID, "downloadUrl": downloadURL})
+}
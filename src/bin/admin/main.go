@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/indexes"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/inference"
+	"api.lnlink.net/src/pkg/services/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// every command prints JSON on stdout so the output can be piped into jq,
+// errors go to stderr as {"error": "..."} with a non zero exit code
+const usage = `usage: admin <command> [flags]
+
+users create          -email [-prompt] [-model] [-tokens]
+users list            [-search] [-limit]
+users show            -email | -id
+users reset-password  -email | -id [-prompt]
+users promote         -email | -id [-revoke]
+tokens grant          -email | -id | -org, -amount, -reason
+experiments list      [-email] [-status] [-limit]
+experiments show      -id
+experiments requeue   -id [-file]
+experiments link      -id
+sso list              [-org]
+sso add               -slug -name -issuer -client-id -domains [-org] [-client-secret-file] [-scopes] [-auto-provision]
+sso update            -slug [-name] [-issuer] [-client-id] [-client-secret-file] [-domains] [-scopes] [-auto-provision]
+sso disable           -slug
+sso enable            -slug
+unlock                -email and/or -ip
+`
+
+type command func(args []string)
+
+var commands = map[string]command{
+	"users create":         createUser,
+	"users list":           listUsers,
+	"users show":           showUser,
+	"users reset-password": resetPassword,
+	"users promote":        promoteUser,
+	"tokens grant":         grantTokens,
+	"experiments list":     listExperiments,
+	"experiments show":     showExperiment,
+	"experiments requeue":  requeueExperiments,
+	"experiments link":     regenerateDownloadLink,
+	"sso list":             listProviders,
+	"sso add":              addProvider,
+	"sso update":           updateProvider,
+	"sso disable":          setProviderEnabled(false),
+	"sso enable":           setProviderEnabled(true),
+	"unlock":               unlock,
+}
+
+func main() {
+	args := os.Args[1:]
+
+	var run command
+	var rest []string
+	if len(args) >= 1 {
+		run, rest = commands[args[0]], args[1:]
+	}
+	if run == nil && len(args) >= 2 {
+		run, rest = commands[args[0]+" "+args[1]], args[2:]
+	}
+	if run == nil {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	global.Init()
+	indexes.Ensure()
+	defer global.Deinit()
+	storage.Init()
+	inference.Init()
+
+	run(rest)
+}
+
+func printJSON(value any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		fail("can't encode output: %v", err)
+	}
+}
+
+// global.Deinit is skipped, the process is going away anyway
+func fail(format string, args ...any) {
+	json.NewEncoder(os.Stderr).Encode(map[string]string{"error": fmt.Sprintf(format, args...)})
+	os.Exit(1)
+}
+
+func parseFlags(set *flag.FlagSet, args []string) {
+	if err := set.Parse(args); err != nil {
+		os.Exit(2)
+	}
+}
+
+// finds a user by -email or -id, one of them is required
+func lookupUser(email string, id string) *user.User {
+	switch {
+	case email != "":
+		if u := user.GetUserByEmail(email); u != nil {
+			return u
+		}
+		fail("no user with email %s", email)
+	case id != "":
+		userID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			fail("invalid user id %s", id)
+		}
+		if u := user.GetUserByID(userID); u != nil {
+			return u
+		}
+		fail("no user with id %s", id)
+	default:
+		fail("pass -email or -id")
+	}
+	return nil
+}
+
+// reads a password from stdin, it is echoed when typed into a terminal
+// so piping it in is preferable
+func promptPassword() string {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		fail("can't read password: %v", err)
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+	if len(password) < user.MIN_PASSWORD_LENGTH {
+		fail("password must be at least %d characters", user.MIN_PASSWORD_LENGTH)
+	}
+	return password
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/organization"
+	"api.lnlink.net/src/pkg/models/sso"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// the client secret is read from a file so it doesn't end up in the shell history
+func readSecret(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fail("can't read client secret: %v", err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// checks that -org names an organization
+func lookupOrganization(hex string) primitive.ObjectID {
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		fail("invalid organization id %s", hex)
+	}
+	if organization.GetByID(id) == nil {
+		fail("no organization with id %s", hex)
+	}
+	return id
+}
+
+func lookupProvider(slug string) *sso.Provider {
+	if slug == "" {
+		fail("pass -slug")
+	}
+	provider, err := sso.FindProvider(slug)
+	if err == sso.ErrProviderNotFound {
+		fail("no sso provider %s", slug)
+	}
+	if err != nil {
+		fail("can't load sso provider: %v", err)
+	}
+	return provider
+}
+
+func recordProviderAudit(action audit.Action, provider *sso.Provider) {
+	event := audit.Event{
+		Action:  action,
+		Details: map[string]any{"providerId": provider.ID, "slug": provider.Slug, "domains": provider.Domains, "via": "cli"},
+	}
+	if !provider.OrganizationID.IsZero() {
+		event.OrganizationID = &provider.OrganizationID
+	}
+	audit.Record(event)
+}
+
+func listProviders(args []string) {
+	set := flag.NewFlagSet("sso list", flag.ContinueOnError)
+	org := set.String("org", "", "only the providers of this organization")
+	parseFlags(set, args)
+
+	var filter *primitive.ObjectID
+	if *org != "" {
+		id := lookupOrganization(*org)
+		filter = &id
+	}
+
+	providers, err := sso.ListProviders(filter)
+	if err != nil {
+		fail("can't list sso providers: %v", err)
+	}
+	views := []map[string]any{}
+	for i := range providers {
+		views = append(views, providers[i].AdminView())
+	}
+	printJSON(views)
+}
+
+func addProvider(args []string) {
+	set := flag.NewFlagSet("sso add", flag.ContinueOnError)
+	body := sso.CreateProvider{}
+	set.StringVar(&body.Slug, "slug", "", "part of the redirect URI, /api/auth/sso/<slug>/callback")
+	set.StringVar(&body.Name, "name", "", "shown on the sign in page")
+	set.StringVar(&body.Issuer, "issuer", "", "issuer URL, the discovery document is read from it")
+	set.StringVar(&body.ClientID, "client-id", "", "client ID registered at the provider")
+	secretFile := set.String("client-secret-file", "", "file holding the client secret, public clients have none")
+	domains := set.String("domains", "", "comma separated email domains that sign in through the provider")
+	scopes := set.String("scopes", "", "comma separated scopes, defaults to openid,email,profile")
+	set.BoolVar(&body.AutoProvision, "auto-provision", false, "create accounts for unknown users")
+	org := set.String("org", "", "organization the provider signs in for, accounts it creates join it")
+	parseFlags(set, args)
+
+	body.Domains = splitList(*domains)
+	body.Scopes = splitList(*scopes)
+	if *secretFile != "" {
+		body.ClientSecret = readSecret(*secretFile)
+	}
+
+	provider := sso.NewProvider(body)
+	if *org != "" {
+		provider.OrganizationID = lookupOrganization(*org)
+	}
+	err := provider.Insert()
+	if err == sso.ErrProviderTaken {
+		fail("slug or domain is already used by another sso provider")
+	}
+	if err != nil {
+		fail("%v", err)
+	}
+
+	recordProviderAudit(audit.ActionSSOProviderCreated, provider)
+	printJSON(provider.AdminView())
+}
+
+func updateProvider(args []string) {
+	set := flag.NewFlagSet("sso update", flag.ContinueOnError)
+	slug := set.String("slug", "", "provider to change")
+	name := set.String("name", "", "")
+	issuer := set.String("issuer", "", "")
+	clientID := set.String("client-id", "", "")
+	secretFile := set.String("client-secret-file", "", "")
+	domains := set.String("domains", "", "replaces the domains")
+	scopes := set.String("scopes", "", "replaces the scopes")
+	autoProvision := set.Bool("auto-provision", false, "")
+	parseFlags(set, args)
+
+	// only the flags that were passed change anything
+	body := sso.UpdateProvider{}
+	set.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			body.Name = name
+		case "issuer":
+			body.Issuer = issuer
+		case "client-id":
+			body.ClientID = clientID
+		case "client-secret-file":
+			secret := readSecret(*secretFile)
+			body.ClientSecret = &secret
+		case "domains":
+			list := splitList(*domains)
+			body.Domains = &list
+		case "scopes":
+			list := splitList(*scopes)
+			body.Scopes = &list
+		case "auto-provision":
+			body.AutoProvision = autoProvision
+		}
+	})
+
+	provider := lookupProvider(*slug)
+	err := provider.Update(body)
+	if err == sso.ErrProviderTaken {
+		fail("a domain is already used by another sso provider")
+	}
+	if err != nil {
+		fail("%v", err)
+	}
+
+	recordProviderAudit(audit.ActionSSOProviderUpdated, provider)
+	printJSON(provider.AdminView())
+}
+
+// disabled providers can't be used to sign in, their users can still reset a password
+func setProviderEnabled(enabled bool) command {
+	name, action := "sso disable", audit.ActionSSOProviderDisabled
+	if enabled {
+		name, action = "sso enable", audit.ActionSSOProviderEnabled
+	}
+
+	return func(args []string) {
+		set := flag.NewFlagSet(name, flag.ContinueOnError)
+		slug := set.String("slug", "", "provider to change")
+		parseFlags(set, args)
+
+		provider := lookupProvider(*slug)
+		if err := provider.SetEnabled(enabled); err != nil {
+			fail("%v", err)
+		}
+
+		recordProviderAudit(action, provider)
+		printJSON(provider.AdminView())
+	}
+}
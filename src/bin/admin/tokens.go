@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/organization"
+	"api.lnlink.net/src/pkg/models/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// negative amounts take tokens away, the reason ends up in the audit log
+func grantTokens(args []string) {
+	set := flag.NewFlagSet("tokens grant", flag.ContinueOnError)
+	email := set.String("email", "", "email of the user")
+	id := set.String("id", "", "id of the user")
+	org := set.String("org", "", "id of an organization, credits its shared wallet instead")
+	amount := set.Int("amount", 0, "tokens to grant, negative to revoke")
+	reason := set.String("reason", "", "why the balance is changed")
+	parseFlags(set, args)
+
+	if *amount == 0 {
+		fail("pass a non zero -amount")
+	}
+	if strings.TrimSpace(*reason) == "" {
+		fail("pass -reason")
+	}
+
+	details := map[string]any{"amount": *amount, "reason": *reason, "via": "cli"}
+
+	if *org != "" {
+		orgID, err := primitive.ObjectIDFromHex(*org)
+		if err != nil {
+			fail("invalid organization id %s", *org)
+		}
+		wallet := organization.GetByID(orgID)
+		if wallet == nil {
+			fail("no organization with id %s", *org)
+		}
+		err = wallet.AddTokens(*amount)
+		if err == organization.ErrInsufficientTokens {
+			fail("organization only has %d tokens", wallet.TokensAvailable)
+		}
+		if err != nil {
+			fail("can't update balance: %v", err)
+		}
+
+		details["balance"] = wallet.TokensAvailable
+		audit.Record(audit.Event{Action: audit.ActionTokensAdjusted, OrganizationID: &wallet.ID, Details: details})
+		printJSON(map[string]any{"organizationId": wallet.ID, "tokensAvailable": wallet.TokensAvailable})
+		return
+	}
+
+	target := lookupUser(*email, *id)
+	balance, err := target.AdjustTokens(*amount)
+	if err == user.ErrInsufficientTokens {
+		fail("user only has %d tokens", target.TokensAvailable)
+	}
+	if err != nil {
+		fail("can't update balance: %v", err)
+	}
+
+	details["balance"] = balance
+	audit.Record(audit.Event{Action: audit.ActionTokensAdjusted, UserID: &target.ID, Details: details})
+	printJSON(map[string]any{"id": target.ID, "email": target.Email, "tokensAvailable": balance})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"slices"
+
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/onetime"
+	"api.lnlink.net/src/pkg/models/throttle"
+	"api.lnlink.net/src/pkg/models/user"
+)
+
+// users made here skip email verification, the operator vouches for the address
+func createUser(args []string) {
+	set := flag.NewFlagSet("users create", flag.ContinueOnError)
+	email := set.String("email", "", "email of the new user")
+	prompt := set.Bool("prompt", false, "read the password from stdin instead of generating one")
+	modelType := set.String("model", user.DEFAULT_MODEL_TYPE, "model type of the user")
+	tokens := set.Int("tokens", -1, "starting balance, defaults to the regular sign up balance")
+	parseFlags(set, args)
+
+	if *email == "" {
+		fail("pass -email")
+	}
+	if !slices.Contains(user.MODEL_TYPES, *modelType) {
+		fail("unknown model type %s", *modelType)
+	}
+	if user.GetUserByEmail(*email) != nil {
+		fail("email %s is already registered", *email)
+	}
+
+	password, generated := "", false
+	if *prompt {
+		password = promptPassword()
+	} else {
+		secret, err := onetime.GenerateSecret()
+		if err != nil {
+			fail("can't generate password: %v", err)
+		}
+		password, generated = secret, true
+	}
+
+	created := user.CreateUser(&user.UserAuth{Email: *email, Password: password}, *modelType)
+	if *tokens >= 0 && *tokens != created.TokensAvailable {
+		if _, err := created.AdjustTokens(*tokens - created.TokensAvailable); err != nil {
+			fail("can't set balance: %v", err)
+		}
+	}
+
+	output := map[string]any{"user": created.AdminView()}
+	if generated {
+		output["password"] = password
+	}
+	printJSON(output)
+}
+
+func listUsers(args []string) {
+	set := flag.NewFlagSet("users list", flag.ContinueOnError)
+	search := set.String("search", "", "part of an email or an exact user id")
+	limit := set.Int("limit", 50, "maximum number of users")
+	parseFlags(set, args)
+
+	users, total, err := user.SearchUsers(*search, 1, *limit)
+	if err != nil {
+		fail("can't list users: %v", err)
+	}
+
+	views := []map[string]any{}
+	for i := range users {
+		views = append(views, users[i].AdminView())
+	}
+	printJSON(map[string]any{"users": views, "total": total})
+}
+
+func showUser(args []string) {
+	set := flag.NewFlagSet("users show", flag.ContinueOnError)
+	email := set.String("email", "", "email of the user")
+	id := set.String("id", "", "id of the user")
+	parseFlags(set, args)
+
+	printJSON(map[string]any{"user": lookupUser(*email, *id).AdminView()})
+}
+
+// also signs the user out everywhere
+func resetPassword(args []string) {
+	set := flag.NewFlagSet("users reset-password", flag.ContinueOnError)
+	email := set.String("email", "", "email of the user")
+	id := set.String("id", "", "id of the user")
+	prompt := set.Bool("prompt", false, "read the password from stdin instead of generating one")
+	parseFlags(set, args)
+
+	target := lookupUser(*email, *id)
+
+	password, generated := "", false
+	if *prompt {
+		password = promptPassword()
+	} else {
+		secret, err := onetime.GenerateSecret()
+		if err != nil {
+			fail("can't generate password: %v", err)
+		}
+		password, generated = secret, true
+	}
+
+	target.ChangePassword(password)
+	if err := throttle.Reset(throttle.KindAccount, throttle.AccountKey(target.Email)); err != nil {
+		fail("can't unlock account: %v", err)
+	}
+	audit.Record(audit.Event{Action: audit.ActionPasswordReset, UserID: &target.ID, Details: map[string]any{"via": "cli"}})
+
+	output := map[string]any{"id": target.ID, "email": target.Email}
+	if generated {
+		output["password"] = password
+	}
+	printJSON(output)
+}
+
+// grants the platform admin role, needed to bootstrap the first admin
+func promoteUser(args []string) {
+	set := flag.NewFlagSet("users promote", flag.ContinueOnError)
+	email := set.String("email", "", "email of the user")
+	id := set.String("id", "", "id of the user")
+	revoke := set.Bool("revoke", false, "revoke the admin role instead")
+	parseFlags(set, args)
+
+	target := lookupUser(*email, *id)
+
+	role := user.RoleAdmin
+	if *revoke {
+		role = ""
+	}
+
+	previousRole := target.Role
+	if err := target.SetRole(role); err != nil {
+		fail("can't update role: %v", err)
+	}
+	audit.Record(audit.Event{
+		Action:  audit.ActionRoleChanged,
+		UserID:  &target.ID,
+		Details: map[string]any{"from": previousRole, "to": role, "via": "cli"},
+	})
+
+	printJSON(map[string]any{"id": target.ID, "email": target.Email, "role": role})
+}
+
+// clears failed login counters so a locked out user can sign in again
+func unlock(args []string) {
+	set := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	email := set.String("email", "", "email of the account to unlock")
+	ip := set.String("ip", "", "IP address to unlock")
+	parseFlags(set, args)
+
+	if *email == "" && *ip == "" {
+		fail("pass -email and/or -ip")
+	}
+
+	unlocked := map[string]any{}
+	if *email != "" {
+		if err := throttle.Reset(throttle.KindAccount, throttle.AccountKey(*email)); err != nil {
+			fail("can't unlock account: %v", err)
+		}
+		unlocked["email"] = *email
+	}
+	if *ip != "" {
+		if err := throttle.Reset(throttle.KindIP, *ip); err != nil {
+			fail("can't unlock IP: %v", err)
+		}
+		unlocked["ip"] = *ip
+	}
+
+	printJSON(map[string]any{"unlocked": unlocked})
+}
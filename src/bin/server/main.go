@@ -3,7 +3,11 @@ package main
 import (
 	"api.lnlink.net/src/pkg/api_server"
 	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/indexes"
+	"api.lnlink.net/src/pkg/models/jwt"
 	"api.lnlink.net/src/pkg/services/cron"
+	"api.lnlink.net/src/pkg/services/inference"
+	"api.lnlink.net/src/pkg/services/storage"
 
 	"github.com/gin-contrib/cors"
 )
@@ -11,7 +15,11 @@ import (
 func main() {
 	// Connect to Mongo
 	global.Init()
+	indexes.Ensure()
 	defer global.Deinit()
+	jwt.InitKeys()
+	storage.Init()
+	inference.Init()
 
 	// Configure CORS
 	global.GIN_ROUTER.Use(cors.New(cors.Config{
@@ -27,6 +35,15 @@ func main() {
 	// Start the experiment status cron job
 	cron.StartExperimentStatusCron()
 
+	// Start the cron job for data exports and account deletions
+	cron.StartJobCron()
+
+	// Start the cron job that drops expired audit events
+	cron.StartAuditRetentionCron()
+
+	// Start the cron job that deletes expired data export archives
+	cron.StartExportCleanupCron()
+
 	api_server.RegisterAllRoutes(global.GIN_ROUTER)
 	err := global.GIN_ROUTER.Run()
 	if err != nil {
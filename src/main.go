@@ -3,16 +3,33 @@ package main
 import (
 	"api.lnlink.net/src/pkg/api_server"
 	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/indexes"
+	"api.lnlink.net/src/pkg/models/jwt"
 	"api.lnlink.net/src/pkg/services/cron"
+	"api.lnlink.net/src/pkg/services/inference"
+	"api.lnlink.net/src/pkg/services/storage"
 )
 
 func main() {
 	global.Init()
+	indexes.Ensure()
 	defer global.Deinit()
+	jwt.InitKeys()
+	storage.Init()
+	inference.Init()
 
 	// Start the experiment status cron job
 	cron.StartExperimentStatusCron()
 
+	// Start the cron job for data exports and account deletions
+	cron.StartJobCron()
+
+	// Start the cron job that drops expired audit events
+	cron.StartAuditRetentionCron()
+
+	// Start the cron job that deletes expired data export archives
+	cron.StartExportCleanupCron()
+
 	// Register all routes
 	api_server.RegisterAllRoutes(global.GIN_ROUTER)
 
@@ -0,0 +1,124 @@
+package api_server
+
+import (
+	"net/http"
+
+	"api.lnlink.net/src/pkg/models/apikey"
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/job"
+	"api.lnlink.net/src/pkg/models/organization"
+	"api.lnlink.net/src/pkg/models/user"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func RegisterAccountDataRoutes(r *gin.Engine) {
+	r.POST("/api/account/export", RequireFeature(FeatureDataExport), AuthMiddleware(), RequireSession(), RequestDataExport)
+	r.DELETE("/api/account", AuthMiddleware(), RequireSession(), RequestAccountDeletion)
+	r.GET("/api/account/jobs", AuthMiddleware(), RequireScope(apikey.ScopeAccountRead), ListAccountJobs)
+	r.GET("/api/account/jobs/:jobId", AuthMiddleware(), RequireScope(apikey.ScopeAccountRead), GetAccountJob)
+	r.POST("/api/account/jobs/status", GetAccountJobStatus)
+}
+
+func queueAccountJob(c *gin.Context, userID primitive.ObjectID, kind job.Kind, action audit.Action) {
+	j, statusToken, err := job.Create(userID, kind)
+	if err == job.ErrAlreadyQueued {
+		c.JSON(http.StatusConflict, gin.H{"error": "A request of this kind is already being processed"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, audit.Event{Action: action, UserID: &userID, Details: map[string]any{"jobId": j.ID}})
+	c.JSON(http.StatusAccepted, gin.H{"job": j, "statusToken": statusToken})
+}
+
+// the archive is built in the background, its link shows up on the job
+func RequestDataExport(c *gin.Context) {
+	queueAccountJob(c, GetUserID(c), job.KindDataExport, audit.ActionDataExportRequested)
+}
+
+// asks for the password again, the deletion can't be undone
+func RequestAccountDeletion(c *gin.Context) {
+	var body user.UserDeleteAccount
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	currentUser := GetAuthenticatedUser(c)
+	success, _ := user.AuthenticateUser(&user.UserAuth{Email: currentUser.Email, Password: body.Password})
+	if !success {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+
+	if currentUser.HasTwoFactor() && !currentUser.VerifyTwoFactor(body.Code) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two factor code"})
+		return
+	}
+
+	if org := organization.GetForUser(currentUser); org != nil && currentUser.OrganizationRole == user.OrganizationOwner {
+		members, err := org.Members()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		owners, err := org.OwnerCount()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if owners <= 1 && len(members) > 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Hand over ownership of your organization before deleting your account"})
+			return
+		}
+	}
+
+	queueAccountJob(c, currentUser.ID, job.KindAccountDeletion, audit.ActionDeletionRequested)
+}
+
+func ListAccountJobs(c *gin.Context) {
+	jobs, err := job.ListForUser(GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+func GetAccountJob(c *gin.Context) {
+	jobID, err := primitive.ObjectIDFromHex(c.Param("jobId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	j := job.GetForUser(jobID, GetUserID(c))
+	if j == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"job": j})
+}
+
+// an account deletion signs the user out, the status token keeps working after that
+func GetAccountJobStatus(c *gin.Context) {
+	var body job.JobStatusToken
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	j := job.GetByStatusToken(body.Token)
+	if j == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"job": j})
+}
@@ -0,0 +1,425 @@
+package api_server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/experiments"
+	"api.lnlink.net/src/pkg/models/jwt"
+	"api.lnlink.net/src/pkg/models/organization"
+	"api.lnlink.net/src/pkg/models/throttle"
+	"api.lnlink.net/src/pkg/models/user"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// r is the /api/admin group, only platform admins get through
+// API keys carry no admin scope, so every admin route needs a session
+func RegisterAdminRoutes(r gin.IRouter) {
+	r.POST("/unlock", RequireSession(), UnlockLogin)
+	r.GET("/users", RequireSession(), ListUsers)
+	r.GET("/users/:userId", RequireSession(), GetUser)
+	r.GET("/users/:userId/experiments", RequireSession(), GetUserExperiments)
+	r.POST("/users/:userId/tokens", RequireSession(), AdjustUserTokens)
+	r.PUT("/users/:userId/model", RequireSession(), SetUserModelType)
+	r.PUT("/users/:userId/role", RequireSession(), SetUserRole)
+	r.POST("/users/:userId/disable", RequireSession(), DisableUser)
+	r.POST("/users/:userId/enable", RequireSession(), EnableUser)
+	r.POST("/users/:userId/logout", RequireSession(), ForceLogoutUser)
+	r.POST("/users/:userId/impersonate", RequireSession(), ImpersonateUser)
+	r.GET("/organizations/:organizationId/tokens", RequireSession(), GetOrganizationTokens)
+	r.POST("/organizations/:organizationId/tokens", RequireSession(), AdjustOrganizationTokens)
+	r.GET("/audit", RequireSession(), ListAuditEvents)
+	RegisterSSOProviderRoutes(r)
+}
+
+// loads the user named in the path, answers with an error and returns nil if it can't
+func loadAdminTarget(c *gin.Context) *user.User {
+	userID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil
+	}
+
+	target := user.GetUserByID(userID)
+	if target == nil || target.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil
+	}
+
+	return target
+}
+
+// loads the organization named in the path, answers with an error and returns nil if it can't
+func loadAdminOrganization(c *gin.Context) *organization.Organization {
+	organizationID, err := primitive.ObjectIDFromHex(c.Param("organizationId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return nil
+	}
+
+	org := organization.GetByID(organizationID)
+	if org == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return nil
+	}
+	return org
+}
+
+// pages through users, search matches part of the email or an exact ID
+func ListUsers(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	users, total, err := user.SearchUsers(strings.TrimSpace(c.Query("search")), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
+		return
+	}
+
+	views := []map[string]any{}
+	for i := range users {
+		views = append(views, users[i].AdminView())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":    views,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+func GetUser(c *gin.Context) {
+	target := loadAdminTarget(c)
+	if target == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": target.AdminView()})
+}
+
+// the experiments the user submitted, including the ones in their organization
+func GetUserExperiments(c *gin.Context) {
+	target := loadAdminTarget(c)
+	if target == nil {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	experiments, total, err := experiments.GetExperiments(target.ID, nil, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get experiments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"experiments": experiments,
+		"total":       total,
+		"page":        page,
+		"pageSize":    pageSize,
+	})
+}
+
+// grants tokens, or takes them away with a negative amount, the reason ends up in the audit log
+func AdjustUserTokens(c *gin.Context) {
+	var body user.AdminAdjustTokens
+	if err := c.ShouldBindJSON(&body); err != nil || body.Amount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reason is required"})
+		return
+	}
+
+	target := loadAdminTarget(c)
+	if target == nil {
+		return
+	}
+
+	balance, err := target.AdjustTokens(body.Amount)
+	if err == user.ErrInsufficientTokens {
+		c.JSON(http.StatusConflict, gin.H{"error": "User doesn't have that many tokens"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, audit.Event{
+		Action:  audit.ActionTokensAdjusted,
+		UserID:  &target.ID,
+		Details: map[string]any{"amount": body.Amount, "reason": body.Reason, "balance": balance},
+	})
+	c.JSON(http.StatusOK, gin.H{"tokensAvailable": balance})
+}
+
+func GetOrganizationTokens(c *gin.Context) {
+	org := loadAdminOrganization(c)
+	if org == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"organizationId": org.ID, "tokensAvailable": org.TokensAvailable})
+}
+
+// the organization counterpart of AdjustUserTokens, changes the shared wallet
+func AdjustOrganizationTokens(c *gin.Context) {
+	var body organization.AdminAdjustTokens
+	if err := c.ShouldBindJSON(&body); err != nil || body.Amount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reason is required"})
+		return
+	}
+
+	org := loadAdminOrganization(c)
+	if org == nil {
+		return
+	}
+
+	err := org.AddTokens(body.Amount)
+	if err == organization.ErrInsufficientTokens {
+		c.JSON(http.StatusConflict, gin.H{"error": "Organization doesn't have that many tokens"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, audit.Event{
+		Action:         audit.ActionTokensAdjusted,
+		OrganizationID: &org.ID,
+		Details:        map[string]any{"amount": body.Amount, "reason": body.Reason, "balance": org.TokensAvailable},
+	})
+	c.JSON(http.StatusOK, gin.H{"organizationId": org.ID, "tokensAvailable": org.TokensAvailable})
+}
+
+func SetUserModelType(c *gin.Context) {
+	var body user.AdminSetModelType
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if !slices.Contains(user.MODEL_TYPES, body.ModelType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid model type"})
+		return
+	}
+
+	target := loadAdminTarget(c)
+	if target == nil {
+		return
+	}
+
+	previousModelType := target.ModelType
+	if err := target.SetModelType(body.ModelType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, audit.Event{
+		Action:  audit.ActionModelTypeChanged,
+		UserID:  &target.ID,
+		Details: map[string]any{"from": previousModelType, "to": body.ModelType},
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Model type updated"})
+}
+
+// also signs the user out everywhere
+func DisableUser(c *gin.Context) {
+	var body user.AdminDisableUser
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	target := loadAdminTarget(c)
+	if target == nil {
+		return
+	}
+
+	if target.ID == GetUserID(c) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You can't disable your own account"})
+		return
+	}
+
+	if err := target.Disable(strings.TrimSpace(body.Reason)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, audit.Event{Action: audit.ActionAccountDisabled, UserID: &target.ID, Details: map[string]any{"reason": body.Reason}})
+	c.JSON(http.StatusOK, gin.H{"message": "Account disabled"})
+}
+
+func EnableUser(c *gin.Context) {
+	target := loadAdminTarget(c)
+	if target == nil {
+		return
+	}
+
+	if err := target.Enable(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, audit.Event{Action: audit.ActionAccountEnabled, UserID: &target.ID})
+	c.JSON(http.StatusOK, gin.H{"message": "Account enabled"})
+}
+
+// ends every session and revokes the refresh tokens, API keys keep working
+func ForceLogoutUser(c *gin.Context) {
+	target := loadAdminTarget(c)
+	if target == nil {
+		return
+	}
+
+	revoked, err := target.RemoveAllSessions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit.Event{Action: audit.ActionForcedLogout, UserID: &target.ID, Details: map[string]any{"revoked": revoked}})
+	c.JSON(http.StatusOK, gin.H{"message": "User logged out", "revoked": revoked})
+}
+
+// hands out a short lived access token for the user with the admin named in
+// its act claim, there is no refresh token and the session shows up on the
+// user's session list
+func ImpersonateUser(c *gin.Context) {
+	target := loadAdminTarget(c)
+	if target == nil {
+		return
+	}
+
+	adminID := GetUserID(c)
+	if target.ID == adminID || target.IsAdmin() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins can't be impersonated"})
+		return
+	}
+
+	if target.IsDisabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account is disabled"})
+		return
+	}
+
+	token, err := jwt.CreateImpersonationJWT(target.ID, adminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	target.AddSession(user.Session{
+		ID:             token.Claims.JWTID,
+		CreatedAt:      now,
+		LastSeenAt:     now,
+		ExpiresAt:      time.Unix(token.Claims.ExpiresAt, 0),
+		IP:             c.ClientIP(),
+		UserAgent:      c.Request.UserAgent(),
+		ImpersonatedBy: &adminID,
+	})
+
+	recordAudit(c, audit.Event{
+		Action:  audit.ActionImpersonationStarted,
+		UserID:  &target.ID,
+		Details: map[string]any{"sessionId": token.Claims.JWTID},
+	})
+	c.JSON(http.StatusOK, gin.H{"accessToken": token.Value, "expiresAt": time.Unix(token.Claims.ExpiresAt, 0)})
+}
+
+// clears failed login counters so a locked out user can sign in again
+func UnlockLogin(c *gin.Context) {
+	var body user.AdminUnlock
+	if err := c.ShouldBindJSON(&body); err != nil || (body.Email == "" && body.IP == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email or IP is required"})
+		return
+	}
+
+	if body.Email != "" {
+		if err := throttle.Reset(throttle.KindAccount, throttle.AccountKey(body.Email)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		event := audit.Event{Action: audit.ActionAccountUnlocked, Details: map[string]any{"email": body.Email}}
+		if unlocked := user.GetUserByEmail(body.Email); unlocked != nil {
+			event.UserID = &unlocked.ID
+		}
+		recordAudit(c, event)
+	}
+
+	if body.IP != "" {
+		if err := throttle.Reset(throttle.KindIP, body.IP); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(c, audit.Event{Action: audit.ActionAccountUnlocked, Details: map[string]any{"ip": body.IP}})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unlocked"})
+}
+
+func SetUserRole(c *gin.Context) {
+	var body user.AdminSetRole
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if body.Role != "" && body.Role != user.RoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	target := loadAdminTarget(c)
+	if target == nil {
+		return
+	}
+
+	// admins can't lock themselves out
+	if target.ID == GetUserID(c) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You can't change your own role"})
+		return
+	}
+
+	previousRole := target.Role
+	if err := target.SetRole(body.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit.Event{
+		Action:  audit.ActionRoleChanged,
+		UserID:  &target.ID,
+		Details: map[string]any{"from": previousRole, "to": body.Role},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated"})
+}
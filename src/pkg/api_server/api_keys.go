@@ -0,0 +1,171 @@
+package api_server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"api.lnlink.net/src/pkg/models/apikey"
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/user"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const APIKeyKey = "apiKey"
+
+func RegisterAPIKeyRoutes(r *gin.Engine) {
+	r.GET("/api/auth/keys", AuthMiddleware(), RequireSession(), ListAPIKeys)
+	r.POST("/api/auth/keys", AuthMiddleware(), RequireSession(), CreateAPIKey)
+	r.DELETE("/api/auth/keys/:id", AuthMiddleware(), RequireSession(), RevokeAPIKey)
+}
+
+// second half of AuthMiddleware for requests that carry an API key instead of a JWT
+func authenticateAPIKey(c *gin.Context, raw string) {
+	key, err := apikey.Authenticate(raw, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked API key"})
+		c.Abort()
+		return
+	}
+
+	keyUser := user.GetUserByID(key.UserID)
+	if keyUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		c.Abort()
+		return
+	}
+
+	if keyUser.PendingVerification {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address is not verified"})
+		c.Abort()
+		return
+	}
+
+	if keyUser.IsDisabled() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		c.Abort()
+		return
+	}
+
+	c.Set(UserIDKey, key.UserID)
+	c.Set(APIKeyKey, key)
+	c.Set(CurrentUserKey, keyUser)
+
+	c.Next()
+}
+
+// returns the API key the request was authenticated with, nil for JWTs
+func GetAPIKey(c *gin.Context) *apikey.APIKey {
+	key, exists := c.Get(APIKeyKey)
+	if !exists {
+		return nil
+	}
+	return key.(*apikey.APIKey)
+}
+
+// rejects API keys that weren't granted the scope, JWTs always pass
+func RequireScope(scope apikey.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := GetAPIKey(c)
+		if key != nil && !key.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key is missing the " + string(scope) + " scope"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// rejects API keys entirely, used for routes that manage credentials
+// admins impersonating a user can't use them either
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetAPIKey(c) != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint can't be used with an API key"})
+			c.Abort()
+			return
+		}
+
+		if GetImpersonatorID(c) != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint can't be used while impersonating"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func ListAPIKeys(c *gin.Context) {
+	keys, err := apikey.ListForUser(GetUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+func CreateAPIKey(c *gin.Context) {
+	var params apikey.CreateAPIKey
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	for _, scope := range params.Scopes {
+		if !apikey.IsValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope " + string(scope)})
+			return
+		}
+	}
+
+	if params.ExpiresAt != nil && params.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	key, raw, err := apikey.Create(GetUserID(c), &params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, audit.Event{
+		Action:  audit.ActionAPIKeyCreated,
+		UserID:  &key.UserID,
+		Details: map[string]any{"keyId": key.ID, "name": key.Name, "scopes": key.Scopes},
+	})
+	c.JSON(http.StatusCreated, gin.H{"key": key, "apiKey": raw})
+}
+
+func RevokeAPIKey(c *gin.Context) {
+	keyID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	err = apikey.Revoke(GetUserID(c), keyID)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := GetUserID(c)
+	recordAudit(c, audit.Event{Action: audit.ActionAPIKeyRevoked, UserID: &userID, Details: map[string]any{"keyId": keyID}})
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
@@ -0,0 +1,106 @@
+package api_server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"api.lnlink.net/src/pkg/models/apikey"
+	"api.lnlink.net/src/pkg/models/audit"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the admin listing is registered in the admin group
+func RegisterAuditRoutes(r *gin.Engine) {
+	r.GET("/api/audit", AuthMiddleware(), RequireScope(apikey.ScopeAccountRead), ListOwnAuditEvents)
+}
+
+// fills in where the request came from and who made it, the actor is only
+// kept when it's not the user the event is about or an admin is impersonating them
+func recordAudit(c *gin.Context, event audit.Event) {
+	event.IP = c.ClientIP()
+	event.UserAgent = c.Request.UserAgent()
+
+	if impersonatorID := GetImpersonatorID(c); impersonatorID != nil {
+		event.ActorID = impersonatorID
+	} else if actorID := GetUserID(c); !actorID.IsZero() && (event.UserID == nil || *event.UserID != actorID) {
+		event.ActorID = &actorID
+	}
+
+	audit.Record(event)
+}
+
+// reads the action, from, to, page and pageSize query parameters
+func parseAuditQuery(c *gin.Context) (audit.Filter, int, int, bool) {
+	filter := audit.Filter{Action: audit.Action(c.Query("action"))}
+
+	for param, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " timestamp, use RFC 3339"})
+			return filter, 0, 0, false
+		}
+		*target = parsed
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 200 {
+		pageSize = 50
+	}
+
+	return filter, page, pageSize, true
+}
+
+func respondAuditEvents(c *gin.Context, filter audit.Filter, page int, pageSize int) {
+	events, total, err := audit.Query(filter, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":   events,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+func ListOwnAuditEvents(c *gin.Context) {
+	filter, page, pageSize, ok := parseAuditQuery(c)
+	if !ok {
+		return
+	}
+
+	userID := GetUserID(c)
+	filter.UserID = &userID
+	respondAuditEvents(c, filter, page, pageSize)
+}
+
+// admins can look at everything or narrow it down to one user
+func ListAuditEvents(c *gin.Context) {
+	filter, page, pageSize, ok := parseAuditQuery(c)
+	if !ok {
+		return
+	}
+
+	if value := c.Query("userId"); value != "" {
+		userID, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		filter.UserID = &userID
+	}
+
+	respondAuditEvents(c, filter, page, pageSize)
+}
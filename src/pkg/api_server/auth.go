@@ -2,8 +2,14 @@ package api_server
 
 import (
 	"net/http"
+	"time"
 
+	"api.lnlink.net/src/pkg/models/apikey"
+	"api.lnlink.net/src/pkg/models/audit"
 	"api.lnlink.net/src/pkg/models/jwt"
+	"api.lnlink.net/src/pkg/models/organization"
+	"api.lnlink.net/src/pkg/models/policy"
+	"api.lnlink.net/src/pkg/models/refresh"
 	"api.lnlink.net/src/pkg/models/user"
 	"api.lnlink.net/src/pkg/services/stripe"
 	"github.com/gin-gonic/gin"
@@ -11,16 +17,26 @@ import (
 )
 
 const (
-	UserIDKey = "userID"
-	TokenKey  = "token"
+	UserIDKey       = "userID"
+	SessionIDKey    = "sessionID"
+	CurrentUserKey  = "currentUser"
+	ImpersonatorKey = "impersonatorID"
 )
 
 func RegisterAuthRoutes(r *gin.Engine) {
+	r.POST("/api/auth/register", RegisterUser)
+	r.POST("/api/auth/verify", VerifyEmail)
+	r.POST("/api/auth/verify/resend", ResendVerificationEmail)
 	r.POST("/api/auth/login", LoginUser)
-	r.PATCH("/api/auth/password", AuthMiddleware(), ChangePassword)
-	r.DELETE("/api/auth/logout", AuthMiddleware(), LogoutUser)
-	r.GET("/api/auth/me", AuthMiddleware(), GetCurrentUser)
-	r.GET("/api/auth/portal", AuthMiddleware(), GetPortalSession)
+	r.POST("/api/auth/refresh", RefreshAccessToken)
+	r.PATCH("/api/auth/password", AuthMiddleware(), RequireSession(), ChangePassword)
+	r.POST("/api/auth/password/forgot", ForgotPassword)
+	r.POST("/api/auth/password/reset", ResetPassword)
+	r.PATCH("/api/auth/email", AuthMiddleware(), RequireSession(), RequestEmailChange)
+	r.POST("/api/auth/email/confirm", ConfirmEmailChange)
+	r.DELETE("/api/auth/logout", AuthMiddleware(), RequireSession(), LogoutUser)
+	r.GET("/api/auth/me", AuthMiddleware(), RequireScope(apikey.ScopeAccountRead), GetCurrentUser)
+	r.GET("/api/auth/portal", RequireFeature(FeaturePurchasing), AuthMiddleware(), RequireScope(apikey.ScopePurchasing), RequirePermission(policy.PurchaseTokens), GetPortalSession)
 }
 
 func GetPortalSession(c *gin.Context) {
@@ -31,7 +47,12 @@ func GetPortalSession(c *gin.Context) {
 		return
 	}
 
-	portalSession, err := stripe.GetPortalSession(user.StripeCustomerID)
+	customerID := user.StripeCustomerID
+	if org := organization.GetForUser(user); org != nil {
+		customerID = org.StripeCustomerID
+	}
+
+	portalSession, err := stripe.GetPortalSession(customerID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -47,26 +68,84 @@ func LoginUser(c *gin.Context) {
 		return
 	}
 
+	if !checkLoginThrottle(c, userAuth.Email) {
+		return
+	}
+
 	success, user := user.AuthenticateUser(&userAuth)
 	if !success {
+		recordLoginFailure(c, userAuth.Email)
+		recordLoginFailureAudit(c, userAuth.Email, "invalid credentials")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
-	jwt, err := jwt.CreateJWT(user.ID)
+	if user.PendingVerification {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address is not verified"})
+		return
+	}
+
+	if user.HasTwoFactor() {
+		startTwoFactorChallenge(c, user)
+		return
+	}
+
+	completeLogin(c, user)
+}
+
+// issues the access token and refresh cookie once every login step passed
+func completeLogin(c *gin.Context, u *user.User) {
+	if u.IsDisabled() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+
+	refreshToken, secret, err := refresh.Issue(u.ID, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	jwt, err := jwt.CreateJWT(u.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	user.AddActiveToken(&jwt)
+	now := time.Now()
+	u.AddSession(user.Session{
+		ID:              jwt.Claims.JWTID,
+		RefreshFamilyID: refreshToken.FamilyID,
+		CreatedAt:       now,
+		LastSeenAt:      now,
+		ExpiresAt:       refreshToken.ExpiresAt,
+		IP:              c.ClientIP(),
+		UserAgent:       c.Request.UserAgent(),
+	})
+	setRefreshCookie(c, secret)
+	resetLoginThrottle(u.Email)
+	recordAudit(c, audit.Event{
+		Action:  audit.ActionLoginSucceeded,
+		UserID:  &u.ID,
+		Details: map[string]any{"sessionId": jwt.Claims.JWTID},
+	})
 
 	c.JSON(http.StatusOK, gin.H{"accessToken": jwt.Value})
 }
 
+// removing the session also revokes its refresh tokens
 func LogoutUser(c *gin.Context) {
 	userID := GetUserID(c)
-	user.GetUserByID(userID).RemoveActiveToken(GetToken(c))
+	// the refresh token would still work, so a failure has to be reported
+	if currentUser := user.GetUserByID(userID); currentUser != nil {
+		if _, err := currentUser.RemoveSession(GetSessionID(c)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	clearRefreshCookie(c)
+	recordAudit(c, audit.Event{Action: audit.ActionLoggedOut, UserID: &userID})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Ok"})
 }
 
@@ -94,7 +173,13 @@ func ChangePassword(c *gin.Context) {
 		return
 	}
 
+	if currentUser.HasTwoFactor() && !currentUser.VerifyTwoFactor(userChangePassword.Code) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two factor code"})
+		return
+	}
+
 	currentUser.ChangePassword(userChangePassword.NewPassword)
+	recordAudit(c, audit.Event{Action: audit.ActionPasswordChanged, UserID: &currentUser.ID})
 	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
 }
 
@@ -115,6 +200,11 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		token := authHeader[7:]
 
+		if apikey.IsAPIKey(token) {
+			authenticateAPIKey(c, token)
+			return
+		}
+
 		valid, jwtToken := jwt.ValidateJWT(token)
 		if !valid {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
@@ -136,28 +226,34 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		if len(user.ActiveTokens) == 0 {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User has no active tokens"})
+		if user.PendingVerification {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address is not verified"})
 			c.Abort()
 			return
 		}
 
-		foundActiveToken := false
-		for _, token := range user.ActiveTokens {
-			if token.Value == jwtToken.Value {
-				foundActiveToken = true
-				break
-			}
+		if user.IsDisabled() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+			c.Abort()
+			return
 		}
 
-		if !foundActiveToken {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is not active"})
+		sessionID := jwtToken.Claims.JWTID
+		if user.GetSession(sessionID) == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session is not active"})
 			c.Abort()
 			return
 		}
 
+		user.TouchSession(sessionID, c.ClientIP(), c.Request.UserAgent())
+
 		c.Set(UserIDKey, userID)
-		c.Set(TokenKey, token)
+		c.Set(SessionIDKey, sessionID)
+		c.Set(CurrentUserKey, user)
+
+		if actor, err := primitive.ObjectIDFromHex(jwtToken.Claims.Actor); err == nil {
+			c.Set(ImpersonatorKey, actor)
+		}
 
 		c.Next()
 	}
@@ -171,12 +267,23 @@ func GetUserID(c *gin.Context) primitive.ObjectID {
 	return userID.(primitive.ObjectID)
 }
 
-func GetToken(c *gin.Context) string {
-	token, exists := c.Get(TokenKey)
+// returns the admin acting as the user, nil outside of impersonation
+func GetImpersonatorID(c *gin.Context) *primitive.ObjectID {
+	impersonatorID, exists := c.Get(ImpersonatorKey)
+	if !exists {
+		return nil
+	}
+	id := impersonatorID.(primitive.ObjectID)
+	return &id
+}
+
+// returns the jti of the access token, empty for API keys
+func GetSessionID(c *gin.Context) string {
+	sessionID, exists := c.Get(SessionIDKey)
 	if !exists {
 		return ""
 	}
-	return token.(string)
+	return sessionID.(string)
 }
 
 func GetCurrentUser(c *gin.Context) {
@@ -192,9 +299,24 @@ func GetCurrentUser(c *gin.Context) {
 		"email":            currentUser.Email,
 		"stripeCustomerID": currentUser.StripeCustomerID,
 		"tokensAvailable":  currentUser.TokensAvailable,
+		"twoFactorEnabled": currentUser.HasTwoFactor(),
+		"role":             currentUser.Role,
 		"createdAt":        currentUser.CreatedAt,
 		"updatedAt":        currentUser.UpdatedAt,
 	}
 
+	if impersonatorID := GetImpersonatorID(c); impersonatorID != nil {
+		response["impersonatedBy"] = impersonatorID
+	}
+
+	if org := organization.GetForUser(currentUser); org != nil {
+		response["organization"] = gin.H{
+			"id":              org.ID,
+			"name":            org.Name,
+			"role":            currentUser.OrganizationRole,
+			"tokensAvailable": org.TokensAvailable,
+		}
+	}
+
 	c.JSON(http.StatusOK, response)
 }
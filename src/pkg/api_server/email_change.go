@@ -0,0 +1,115 @@
+package api_server
+
+import (
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/onetime"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/email"
+	"api.lnlink.net/src/pkg/services/stripe"
+	"github.com/gin-gonic/gin"
+)
+
+// the address only switches once the link sent to the new one is opened
+func RequestEmailChange(c *gin.Context) {
+	var body user.UserChangeEmail
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	body.NewEmail = user.NormalizeEmail(body.NewEmail)
+	if _, err := mail.ParseAddress(body.NewEmail); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+		return
+	}
+
+	currentUser := GetAuthenticatedUser(c)
+	if strings.EqualFold(body.NewEmail, currentUser.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This is already your email address"})
+		return
+	}
+
+	success, _ := user.AuthenticateUser(&user.UserAuth{Email: currentUser.Email, Password: body.Password})
+	if !success {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+
+	if currentUser.HasTwoFactor() && !currentUser.VerifyTwoFactor(body.Code) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two factor code"})
+		return
+	}
+
+	if existing := user.GetUserByEmail(body.NewEmail); existing != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+		return
+	}
+
+	token, err := onetime.Issue(currentUser.ID, onetime.PurposeEmailChange, onetime.EMAIL_CHANGE_EXPIRATION_TIME, body.NewEmail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	email.SendEmailChangeConfirmation(body.NewEmail, appLink("/confirm-email", token))
+	email.SendEmailChangeNotice(currentUser.Email, body.NewEmail)
+	recordAudit(c, audit.Event{Action: audit.ActionEmailChangeRequested, UserID: &currentUser.ID, Details: map[string]any{"newEmail": body.NewEmail}})
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Check the new inbox to confirm the change"})
+}
+
+// opening the link proves access to the new inbox, no session needed
+func ConfirmEmailChange(c *gin.Context) {
+	var body user.UserVerifyEmail
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	token, err := onetime.Consume(body.Token, onetime.PurposeEmailChange)
+	if err == onetime.ErrInvalidToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation link"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	changedUser := user.GetUserByID(token.UserID)
+	if changedUser == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation link"})
+		return
+	}
+
+	previousEmail := changedUser.Email
+	err = changedUser.ChangeEmail(token.Payload)
+	if err == user.ErrEmailTaken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, audit.Event{
+		Action:  audit.ActionEmailChanged,
+		UserID:  &changedUser.ID,
+		Details: map[string]any{"from": previousEmail, "to": changedUser.Email},
+	})
+
+	// the address already switched, a billing hiccup shouldn't undo that
+	if changedUser.StripeCustomerID != "" {
+		if err := stripe.UpdateCustomerEmail(changedUser.StripeCustomerID, changedUser.Email); err != nil {
+			log.Printf("Failed to update stripe customer %s email: %v", changedUser.StripeCustomerID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email changed"})
+}
@@ -10,16 +10,17 @@ import (
 	"strconv"
 
 	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/apikey"
+	"api.lnlink.net/src/pkg/models/audit"
 	"api.lnlink.net/src/pkg/models/experiments"
+	"api.lnlink.net/src/pkg/models/organization"
+	"api.lnlink.net/src/pkg/models/policy"
 	"api.lnlink.net/src/pkg/models/user"
-	"api.lnlink.net/src/pkg/services/models"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"api.lnlink.net/src/pkg/services/inference"
+	"api.lnlink.net/src/pkg/services/pipeline"
+	"api.lnlink.net/src/pkg/services/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -27,7 +28,13 @@ func CreateExperiment(c *gin.Context) {
 	userID := GetUserID(c)
 	user := user.GetUserByID(userID)
 
-	if user.ModelType != "innocent" {
+	// members of an organization use the model their organization pays for
+	modelType := user.ModelType
+	if org := organization.GetForUser(user); org != nil {
+		modelType = org.ModelType
+	}
+
+	if modelType != "innocent" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid model type"})
 		return
 	}
@@ -64,22 +71,16 @@ func CreateExperiment(c *gin.Context) {
 		return
 	}
 
-	// Configure AWS S3 client
-	cfg, err := config.LoadDefaultConfig(c.Request.Context(),
-		config.WithRegion(global.S3_REGION),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			global.S3_ACCESS_KEY_ID,
-			global.S3_SECRET_ACCESS_KEY,
-			"",
-		)),
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to configure AWS"})
+	exp := experiments.MultiExperiment{
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		OrganizationID: user.OrganizationID,
+		ModelType:      modelType,
+	}
+	if !checkBalance(c, &exp, len(files)) {
 		return
 	}
 
-	s3Client := s3.NewFromConfig(cfg)
-
 	// Process each file
 	var uploadedFiles []string
 	var experimentIDs []string
@@ -108,67 +109,55 @@ func CreateExperiment(c *gin.Context) {
 
 		// Generate filename using the experiment ID
 		newFilename := fmt.Sprintf("%s%s", experimentID, ext)
-		s3Key := fmt.Sprintf("innocent/%s", newFilename)
+		inputKey := fmt.Sprintf("innocent/%s", newFilename)
 
-		// Upload to S3
-		_, err = s3Client.PutObject(c.Request.Context(), &s3.PutObjectInput{
-			Bucket: aws.String(global.S3_INPUT_BUCKET_NAME),
-			Key:    aws.String(s3Key),
-			Body:   src,
-		})
+		// Upload to storage
+		err = storage.Client().Put(c.Request.Context(), global.CONFIG.Storage.InputBucket, inputKey, src, file.Header.Get("Content-Type"))
 		if err != nil {
 			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file to S3"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
 			return
 		}
 
-		uploadedFiles = append(uploadedFiles, s3Key)
-	}
-
-	// Process each uploaded file
-	var responses []*models.InnocentResponse
-	for i, inputFile := range uploadedFiles {
-		// Create experiment with input parameters
-		requestBody := models.InnocentInputParams{
-			S3InputBucketName:    global.S3_INPUT_BUCKET_NAME,
-			S3InputFilePath:      inputFile,
-			S3OutputBucketName:   global.S3_OUTPUT_BUCKET_NAME,
-			S3OutputMaskFilePath: fmt.Sprintf("innocent/%s.png", experimentIDs[i]),
-			S3OutputResultsPath:  fmt.Sprintf("innocent/%s.json", experimentIDs[i]),
-			S3OutputTablePath:    fmt.Sprintf("innocent/%s.xlsx", experimentIDs[i]),
-			NRays:                32,
-			MicronsPerPixel:      micronsPerPixel,
+		uploadedFiles = append(uploadedFiles, inputKey)
+	}
+
+	// Submit each uploaded file to the inference backend, callbacks point at the group
+	exps := []experiments.Experiment{}
+	responses := []gin.H{}
+	for i := range uploadedFiles {
+		experiment := experiments.Experiment{
+			FileID:          experimentIDs[i],
+			FileExtension:   filepath.Ext(files[i].Filename),
+			MicronsPerPixel: micronsPerPixel,
 		}
 
-		// Make request to processing service
-		response, err := models.InnocentMakeRequest(requestBody)
+		jobID, err := pipeline.Submit(c.Request.Context(), exp.ID, experiment)
 		if err != nil {
+			log.Printf("Failed to submit %s: %v", experiment.FileID, err)
+			cancelSubmitted(exps)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process experiment"})
 			return
 		}
 
-		responses = append(responses, response)
-	}
-
-	exps := []experiments.Experiment{}
-	for i, response := range responses {
-		exps = append(exps, experiments.Experiment{
-			FileID:        experimentIDs[i],
-			FileExtension: filepath.Ext(files[i].Filename),
-			RunpodID:      response.ID,
-			Status:        experiments.ExperimentInProgress,
-		})
-	}
-	exp := experiments.MultiExperiment{
-		UserID:      userID,
-		Experiments: exps,
+		exps = append(exps, experiment.Submitted(jobID))
+		responses = append(responses, gin.H{"id": jobID, "status": inference.StateQueued})
 	}
+	exp.Experiments = exps
 	err = exp.Create(userID, name)
 	if err != nil {
+		cancelSubmitted(exps)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create experiment"})
 		return
 	}
 
+	recordAudit(c, audit.Event{
+		Action:         audit.ActionExperimentCreated,
+		UserID:         &userID,
+		OrganizationID: exp.OrganizationID,
+		Details:        map[string]any{"experimentId": exp.ID, "name": name, "images": len(exps)},
+	})
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "Experiments created successfully",
 		"name":      name,
@@ -176,15 +165,30 @@ func CreateExperiment(c *gin.Context) {
 	})
 }
 
+// nothing would ever pick up the results of a group that wasn't stored,
+// so its jobs are cancelled instead of running uncharged
+func cancelSubmitted(exps []experiments.Experiment) {
+	for _, exp := range exps {
+		if err := inference.Client().Cancel(context.Background(), exp.JobID); err != nil {
+			log.Printf("Failed to cancel job %s: %v", exp.JobID, err)
+		}
+	}
+}
+
 func GetExperiments(c *gin.Context) {
 	userID := GetUserID(c)
+	currentUser := user.GetUserByID(userID)
+	if currentUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
 
 	// Get pagination parameters
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
 
 	// Get experiments
-	experiments, total, err := experiments.GetExperiments(userID, page, pageSize)
+	experiments, total, err := experiments.GetExperiments(userID, currentUser.OrganizationID, page, pageSize)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get experiments"})
 		return
@@ -199,8 +203,6 @@ func GetExperiments(c *gin.Context) {
 }
 
 func GetExperimentDownloadLink(c *gin.Context) {
-	userID := GetUserID(c)
-
 	// Get experiment ID from URL
 	experimentID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
@@ -208,18 +210,28 @@ func GetExperimentDownloadLink(c *gin.Context) {
 		return
 	}
 
-	// Verify experiment belongs to user
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(experiments.MultiExperimentCollection)
-	var experiment experiments.MultiExperiment
-	err = collection.FindOne(context.Background(), bson.M{
-		"_id":    experimentID,
-		"userId": userID,
-	}).Decode(&experiment)
-	if err != nil {
+	// Verify experiment is visible to the user, hidden ones look missing
+	experiment, err := experiments.GetExperimentByID(experimentID)
+	if err != nil || !policy.CanViewExperiment(GetAuthenticatedUser(c), experiment) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Experiment not found"})
 		return
 	}
 
+	// a link built while images still run would leave them out, and Finish
+	// wouldn't replace it once they are done
+	if experiment.DownloadURL == "" && !pipeline.Finished(experiment) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Experiment isn't finished yet"})
+		return
+	}
+
+	currentUser := GetAuthenticatedUser(c)
+	recordAudit(c, audit.Event{
+		Action:         audit.ActionExperimentDownloaded,
+		UserID:         &currentUser.ID,
+		OrganizationID: experiment.OrganizationID,
+		Details:        map[string]any{"experimentId": experiment.ID, "submittedBy": experiment.UserID},
+	})
+
 	// If download URL is already generated, return it
 	if experiment.DownloadURL != "" {
 		c.JSON(http.StatusOK, gin.H{
@@ -236,12 +248,7 @@ func GetExperimentDownloadLink(c *gin.Context) {
 	}
 
 	// Store the generated URL in the database
-	_, err = collection.UpdateOne(
-		context.Background(),
-		bson.M{"_id": experimentID},
-		bson.M{"$set": bson.M{"downloadUrl": downloadURL}},
-	)
-	if err != nil {
+	if err := experiment.SetDownloadURL(downloadURL); err != nil {
 		log.Printf("Failed to store download URL: %v", err)
 	}
 
@@ -250,8 +257,255 @@ func GetExperimentDownloadLink(c *gin.Context) {
 	})
 }
 
-func RegisterExperimentRoutes(router *gin.Engine) {
-	router.POST("/api/experiments", AuthMiddleware(), CreateExperiment)
-	router.GET("/api/experiments", AuthMiddleware(), GetExperiments)
-	router.GET("/api/experiments/:id/download", AuthMiddleware(), GetExperimentDownloadLink)
+// cancels every image of the group that is still in progress
+func CancelExperiment(c *gin.Context) {
+	experiment := modifiableExperiment(c)
+	if experiment == nil {
+		return
+	}
+
+	cancelled := []string{}
+	for _, exp := range experiment.Experiments {
+		if exp.Status != experiments.ExperimentInProgress {
+			continue
+		}
+
+		ok, err := pipeline.Cancel(experiment.ID, exp.FileID)
+		if err == pipeline.ErrClaimed {
+			c.JSON(http.StatusConflict, gin.H{"error": "Experiment is being processed, try again", "cancelled": cancelled})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to cancel %s: %v", exp.FileID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel experiment", "cancelled": cancelled})
+			return
+		}
+		if ok {
+			cancelled = append(cancelled, exp.FileID)
+		}
+	}
+
+	finishCancelled(c, experiment, cancelled)
+}
+
+// cancels a single image of the group
+func CancelExperimentImage(c *gin.Context) {
+	experiment := modifiableExperiment(c)
+	if experiment == nil {
+		return
+	}
+
+	fileID := c.Param("fileId")
+	ok, err := pipeline.Cancel(experiment.ID, fileID)
+	if err == pipeline.ErrUnknownExperiment {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	if err == pipeline.ErrClaimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Image is being processed, try again"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to cancel %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel image"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Image is no longer in progress"})
+		return
+	}
+
+	finishCancelled(c, experiment, []string{fileID})
+}
+
+// resubmits the chosen images of the group, or every failed one when none are chosen.
+// each rerun is charged like a new image once it completes
+func RerunExperiment(c *gin.Context) {
+	experiment := modifiableExperiment(c)
+	if experiment == nil {
+		return
+	}
+
+	var body experiments.RerunRequest
+	if !bindRerunRequest(c, &body) {
+		return
+	}
+
+	fileIDs := body.FileIDs
+	if len(fileIDs) == 0 {
+		for _, exp := range experiment.Experiments {
+			if exp.Status == experiments.ExperimentFailed {
+				fileIDs = append(fileIDs, exp.FileID)
+			}
+		}
+	}
+
+	if !checkBalance(c, experiment, len(fileIDs)) {
+		return
+	}
+
+	rerun := []string{}
+	for _, fileID := range fileIDs {
+		ok, err := pipeline.Rerun(experiment.ID, fileID, body.MicronsPerPixel, GetUserID(c))
+		if err == pipeline.ErrUnknownExperiment {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Image %s not found", fileID), "rerun": rerun})
+			return
+		}
+		if err == pipeline.ErrClaimed {
+			c.JSON(http.StatusConflict, gin.H{"error": "Experiment is being processed, try again", "rerun": rerun})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to rerun %s: %v", fileID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rerun experiment", "rerun": rerun})
+			return
+		}
+		if ok {
+			rerun = append(rerun, fileID)
+		}
+	}
+
+	recordRerun(c, experiment, rerun, body.MicronsPerPixel)
+	c.JSON(http.StatusOK, gin.H{"rerun": rerun})
+}
+
+// resubmits a single failed or completed image
+func RerunExperimentImage(c *gin.Context) {
+	experiment := modifiableExperiment(c)
+	if experiment == nil {
+		return
+	}
+
+	var body experiments.RerunRequest
+	if !bindRerunRequest(c, &body) {
+		return
+	}
+
+	if !checkBalance(c, experiment, 1) {
+		return
+	}
+
+	fileID := c.Param("fileId")
+	ok, err := pipeline.Rerun(experiment.ID, fileID, body.MicronsPerPixel, GetUserID(c))
+	if err == pipeline.ErrUnknownExperiment {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+	if err == pipeline.ErrClaimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Image is being processed, try again"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to rerun %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rerun image"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only failed or completed images can be rerun"})
+		return
+	}
+
+	recordRerun(c, experiment, []string{fileID}, body.MicronsPerPixel)
+	c.JSON(http.StatusOK, gin.H{"rerun": []string{fileID}})
+}
+
+// the wallet the group is charged to has to cover the images before they are
+// submitted, answers with an error and returns false if it doesn't
+func checkBalance(c *gin.Context, experiment *experiments.MultiExperiment, images int) bool {
+	err := pipeline.CheckBalance(experiment, images)
+	if err == pipeline.ErrInsufficientTokens {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Not enough tokens"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check balance"})
+		return false
+	}
+	return true
+}
+
+// the body is optional, without one the images rerun with their old parameters
+func bindRerunRequest(c *gin.Context, body *experiments.RerunRequest) bool {
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return false
+		}
+	}
+	if body.MicronsPerPixel != nil && *body.MicronsPerPixel <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid micronsPerPixel value"})
+		return false
+	}
+	return true
+}
+
+func recordRerun(c *gin.Context, experiment *experiments.MultiExperiment, fileIDs []string, micronsPerPixel *float64) {
+	if len(fileIDs) == 0 {
+		return
+	}
+
+	details := map[string]any{"experimentId": experiment.ID, "fileIds": fileIDs, "submittedBy": experiment.UserID}
+	if micronsPerPixel != nil {
+		details["micronsPerPixel"] = *micronsPerPixel
+	}
+	currentUser := GetAuthenticatedUser(c)
+	recordAudit(c, audit.Event{
+		Action:         audit.ActionExperimentRerun,
+		UserID:         &currentUser.ID,
+		OrganizationID: experiment.OrganizationID,
+		Details:        details,
+	})
+}
+
+// looks up the experiment of the request, responding with 404 when the user
+// can't see it and 403 when they can see but not change it
+func modifiableExperiment(c *gin.Context) *experiments.MultiExperiment {
+	experimentID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid experiment ID"})
+		return nil
+	}
+
+	currentUser := GetAuthenticatedUser(c)
+	experiment, err := experiments.GetExperimentByID(experimentID)
+	if err != nil || !policy.CanViewExperiment(currentUser, experiment) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Experiment not found"})
+		return nil
+	}
+	if !policy.CanModifyExperiment(currentUser, experiment) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to do this"})
+		return nil
+	}
+
+	return experiment
+}
+
+// records the cancellation and builds the download link if the rest of the group is done
+func finishCancelled(c *gin.Context, experiment *experiments.MultiExperiment, cancelled []string) {
+	if len(cancelled) > 0 {
+		currentUser := GetAuthenticatedUser(c)
+		recordAudit(c, audit.Event{
+			Action:         audit.ActionExperimentCancelled,
+			UserID:         &currentUser.ID,
+			OrganizationID: experiment.OrganizationID,
+			Details:        map[string]any{"experimentId": experiment.ID, "fileIds": cancelled, "submittedBy": experiment.UserID},
+		})
+
+		if updated, err := experiments.GetExperimentByID(experiment.ID); err == nil {
+			pipeline.Finish(updated)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cancelled": cancelled})
+}
+
+// r is the /api/experiments group
+func RegisterExperimentRoutes(r gin.IRouter) {
+	r.POST("", RequireFeature(FeatureSubmissions), RequireScope(apikey.ScopeExperimentsWrite), CreateExperiment)
+	r.GET("", RequireScope(apikey.ScopeExperimentsRead), GetExperiments)
+	r.GET("/:id/download", RequireScope(apikey.ScopeExperimentsRead), GetExperimentDownloadLink)
+	r.POST("/:id/cancel", RequireFeature(FeatureSubmissions), RequireScope(apikey.ScopeExperimentsWrite), CancelExperiment)
+	r.POST("/:id/images/:fileId/cancel", RequireFeature(FeatureSubmissions), RequireScope(apikey.ScopeExperimentsWrite), CancelExperimentImage)
+	r.POST("/:id/rerun", RequireFeature(FeatureSubmissions), RequireScope(apikey.ScopeExperimentsWrite), RerunExperiment)
+	r.POST("/:id/images/:fileId/rerun", RequireFeature(FeatureSubmissions), RequireScope(apikey.ScopeExperimentsWrite), RerunExperimentImage)
 }
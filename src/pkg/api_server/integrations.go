@@ -0,0 +1,58 @@
+package api_server
+
+import (
+	"net/http"
+
+	"api.lnlink.net/src/pkg/global"
+	"github.com/gin-gonic/gin"
+)
+
+// the parts of the API that depend on an optional integration
+type Feature string
+
+const (
+	FeaturePurchasing  Feature = "purchasing"
+	FeatureExperiments Feature = "experiments"
+	FeatureSubmissions Feature = "submissions"
+	FeatureDataExport  Feature = "dataExport"
+	FeatureEmail       Feature = "email"
+)
+
+func featureEnabled(feature Feature) bool {
+	switch feature {
+	case FeaturePurchasing:
+		return global.CONFIG.Stripe.Enabled()
+	case FeatureExperiments, FeatureDataExport:
+		return global.CONFIG.Storage.Enabled()
+	case FeatureSubmissions:
+		return global.CONFIG.Storage.Enabled() && global.CONFIG.Inference.Enabled()
+	case FeatureEmail:
+		return global.CONFIG.Email.Enabled()
+	}
+	return false
+}
+
+func RegisterFeatureRoutes(r *gin.Engine) {
+	r.GET("/api/features", GetFeatures)
+}
+
+// lets the frontend hide what this server can't do
+func GetFeatures(c *gin.Context) {
+	features := gin.H{}
+	for _, feature := range []Feature{FeaturePurchasing, FeatureExperiments, FeatureSubmissions, FeatureDataExport, FeatureEmail} {
+		features[string(feature)] = featureEnabled(feature)
+	}
+	c.JSON(http.StatusOK, gin.H{"features": features})
+}
+
+// rejects requests for a feature whose integration isn't configured
+func RequireFeature(feature Feature) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !featureEnabled(feature) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "This feature is not available on this server", "feature": feature})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
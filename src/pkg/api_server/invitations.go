@@ -0,0 +1,278 @@
+package api_server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"api.lnlink.net/src/pkg/models/invitation"
+	"api.lnlink.net/src/pkg/models/organization"
+	"api.lnlink.net/src/pkg/models/policy"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/email"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the invitation management routes live in the organizations group
+func RegisterInvitationRoutes(r *gin.Engine) {
+	r.POST("/api/invitations/preview", PreviewInvitation)
+	r.POST("/api/invitations/accept", AuthMiddleware(), RequireSession(), AcceptInvitation)
+	r.POST("/api/invitations/register", AcceptInvitationRegister)
+}
+
+func sendInvitationEmail(inv *invitation.Invitation, org *organization.Organization, inviter *user.User, secret string) {
+	email.SendInvitationEmail(inv.Email, org.Name, inviter.Email, appLink("/invitations/accept", secret))
+}
+
+// the organization the signed in user manages, RequirePermission already ran
+func loadManagedOrganization(c *gin.Context) (*user.User, *organization.Organization) {
+	currentUser := GetAuthenticatedUser(c)
+	org := organization.GetForUser(currentUser)
+	if org == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You are not in an organization"})
+		return nil, nil
+	}
+	return currentUser, org
+}
+
+func ListInvitations(c *gin.Context) {
+	_, org := loadManagedOrganization(c)
+	if org == nil {
+		return
+	}
+
+	invitations, err := invitation.ListPending(org.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+func CreateInvitation(c *gin.Context) {
+	var body invitation.CreateInvitation
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	body.Email = strings.TrimSpace(body.Email)
+	if _, err := mail.ParseAddress(body.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+		return
+	}
+
+	if body.Role == "" {
+		body.Role = user.OrganizationMember
+	}
+	if _, ok := policy.ORGANIZATION_ROLE_PERMISSIONS[body.Role]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	currentUser, org := loadManagedOrganization(c)
+	if org == nil {
+		return
+	}
+
+	if existing := user.GetUserByEmail(body.Email); existing != nil && existing.OrganizationID != nil && *existing.OrganizationID == org.ID {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already a member"})
+		return
+	}
+
+	inv, secret, err := invitation.Create(org.ID, body.Email, body.Role, currentUser.ID)
+	if err == invitation.ErrAlreadyInvited {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already has a pending invitation, resend it instead"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sendInvitationEmail(inv, org, currentUser, secret)
+	c.JSON(http.StatusCreated, gin.H{"invitation": inv})
+}
+
+func ResendInvitation(c *gin.Context) {
+	invitationID, err := primitive.ObjectIDFromHex(c.Param("invitationId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return
+	}
+
+	currentUser, org := loadManagedOrganization(c)
+	if org == nil {
+		return
+	}
+
+	inv, secret, err := invitation.Resend(invitationID, org.ID)
+	if err == invitation.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sendInvitationEmail(inv, org, currentUser, secret)
+	c.JSON(http.StatusOK, gin.H{"invitation": inv})
+}
+
+func RevokeInvitation(c *gin.Context) {
+	invitationID, err := primitive.ObjectIDFromHex(c.Param("invitationId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return
+	}
+
+	_, org := loadManagedOrganization(c)
+	if org == nil {
+		return
+	}
+
+	err = invitation.Revoke(invitationID, org.ID)
+	if err == invitation.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+}
+
+// tells the accept page which organization invited them and whether
+// they should sign in or create an account
+func PreviewInvitation(c *gin.Context) {
+	var body invitation.InvitationToken
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	inv, err := invitation.Lookup(body.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		return
+	}
+
+	org := organization.GetByID(inv.OrganizationID)
+	if org == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"organization":  org.Name,
+		"email":         inv.Email,
+		"role":          inv.Role,
+		"expiresAt":     inv.ExpiresAt,
+		"accountExists": user.GetUserByEmail(inv.Email) != nil,
+	})
+}
+
+// links an existing account, the user has to be signed in as the invited address
+func AcceptInvitation(c *gin.Context) {
+	var body invitation.InvitationToken
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	inv, err := invitation.Lookup(body.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		return
+	}
+
+	currentUser := GetAuthenticatedUser(c)
+	if !inv.IsFor(currentUser) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This invitation was sent to a different email address"})
+		return
+	}
+
+	if joinOrganization(c, inv, currentUser) {
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation accepted"})
+	}
+}
+
+// creates the account and signs it in, the invited address counts as verified
+func AcceptInvitationRegister(c *gin.Context) {
+	var body invitation.AcceptInvitationRegister
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if len(body.Password) < user.MIN_PASSWORD_LENGTH {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", user.MIN_PASSWORD_LENGTH)})
+		return
+	}
+
+	inv, err := invitation.Lookup(body.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		return
+	}
+
+	if existing := user.GetUserByEmail(inv.Email); existing != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email exists, sign in to accept the invitation"})
+		return
+	}
+
+	// the invitation is used up first so two requests with one token can't both create an account
+	newUserID := primitive.NewObjectID()
+	if err := inv.MarkAccepted(newUserID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		return
+	}
+
+	newUser, err := user.CreateInvitedUser(newUserID, inv.Email, body.Password, inv.OrganizationID, inv.Role)
+	if err != nil {
+		if reopenErr := inv.Reopen(newUserID); reopenErr != nil {
+			log.Printf("Failed to reopen invitation %s: %v", inv.ID.Hex(), reopenErr)
+		}
+	}
+	if err == user.ErrEmailTaken {
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email exists, sign in to accept the invitation"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create invited user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+		return
+	}
+
+	completeLogin(c, newUser)
+}
+
+// uses up the invitation and adds the user to the organization
+func joinOrganization(c *gin.Context, inv *invitation.Invitation, u *user.User) bool {
+	if u.InOrganization() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Leave your current organization before joining another one"})
+		return false
+	}
+
+	if err := inv.MarkAccepted(u.ID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		return false
+	}
+
+	if err := u.SetOrganization(inv.OrganizationID, inv.Role); err != nil {
+		if reopenErr := inv.Reopen(u.ID); reopenErr != nil {
+			log.Printf("Failed to reopen invitation %s: %v", inv.ID.Hex(), reopenErr)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,18 @@
+package api_server
+
+import (
+	"net/http"
+
+	"api.lnlink.net/src/pkg/models/jwt"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterJWKSRoutes(r *gin.Engine) {
+	r.GET("/.well-known/jwks.json", GetJWKS)
+}
+
+// lets other services validate our access tokens without sharing a secret
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwt.PublicKeySet())
+}
@@ -0,0 +1,90 @@
+package api_server
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/throttle"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/email"
+	"github.com/gin-gonic/gin"
+)
+
+// rejects the login before bcrypt runs if the account or IP has to wait
+// answers with 429 and returns false when blocked
+func checkLoginThrottle(c *gin.Context, accountEmail string) bool {
+	accountWait, err := throttle.Check(throttle.KindAccount, throttle.AccountKey(accountEmail))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	ipWait, err := throttle.Check(throttle.KindIP, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	wait := accountWait
+	if ipWait > wait {
+		wait = ipWait
+	}
+	if wait == 0 {
+		return true
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", fmt.Sprint(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      "Too many failed login attempts, try again later",
+		"retryAfter": seconds,
+	})
+	return false
+}
+
+// counts a failed login against the account and the IP
+// the owner gets an email when their account gets locked
+func recordLoginFailure(c *gin.Context, accountEmail string) {
+	accountThrottle, locked, err := throttle.RecordFailure(throttle.KindAccount, throttle.AccountKey(accountEmail))
+	if err != nil {
+		log.Printf("Failed to record login failure for account: %v", err)
+	} else if locked {
+		log.Printf("Account %s locked until %s after %d failed logins", accountEmail, accountThrottle.LockedUntil, accountThrottle.Failures)
+		if owner := user.GetUserByEmail(accountEmail); owner != nil {
+			email.SendAccountLockedEmail(owner.Email, *accountThrottle.LockedUntil)
+			recordAudit(c, audit.Event{
+				Action:  audit.ActionAccountLocked,
+				UserID:  &owner.ID,
+				Details: map[string]any{"until": *accountThrottle.LockedUntil, "failures": accountThrottle.Failures},
+			})
+		}
+	}
+
+	ipThrottle, locked, err := throttle.RecordFailure(throttle.KindIP, c.ClientIP())
+	if err != nil {
+		log.Printf("Failed to record login failure for IP: %v", err)
+	} else if locked {
+		log.Printf("IP %s locked until %s after %d failed logins", c.ClientIP(), ipThrottle.LockedUntil, ipThrottle.Failures)
+	}
+}
+
+// failed logins of unknown emails are recorded too, without a user
+func recordLoginFailureAudit(c *gin.Context, accountEmail string, reason string) {
+	event := audit.Event{
+		Action:  audit.ActionLoginFailed,
+		Details: map[string]any{"email": accountEmail, "reason": reason},
+	}
+	if owner := user.GetUserByEmail(accountEmail); owner != nil {
+		event.UserID = &owner.ID
+	}
+	recordAudit(c, event)
+}
+
+func resetLoginThrottle(accountEmail string) {
+	if err := throttle.Reset(throttle.KindAccount, throttle.AccountKey(accountEmail)); err != nil {
+		log.Printf("Failed to reset login throttle: %v", err)
+	}
+}
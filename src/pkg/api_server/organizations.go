@@ -0,0 +1,195 @@
+package api_server
+
+import (
+	"net/http"
+	"strings"
+
+	"api.lnlink.net/src/pkg/models/apikey"
+	"api.lnlink.net/src/pkg/models/organization"
+	"api.lnlink.net/src/pkg/models/policy"
+	"api.lnlink.net/src/pkg/models/user"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// r is the /api/organizations group
+func RegisterOrganizationRoutes(r gin.IRouter) {
+	r.POST("", RequireSession(), CreateOrganization)
+	r.GET("/current", RequireScope(apikey.ScopeAccountRead), GetCurrentOrganization)
+	r.PATCH("/current/members/:userId", RequireSession(), RequirePermission(policy.ManageOrganization), UpdateOrganizationMember)
+	r.DELETE("/current/members/:userId", RequireSession(), RemoveOrganizationMember)
+
+	manage := RequirePermission(policy.ManageOrganization)
+	r.GET("/current/invitations", RequireScope(apikey.ScopeAccountRead), manage, ListInvitations)
+	r.POST("/current/invitations", RequireSession(), manage, CreateInvitation)
+	r.POST("/current/invitations/:invitationId/resend", RequireSession(), manage, ResendInvitation)
+	r.DELETE("/current/invitations/:invitationId", RequireSession(), manage, RevokeInvitation)
+}
+
+func CreateOrganization(c *gin.Context) {
+	var body organization.CreateOrganization
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	currentUser := user.GetUserByID(GetUserID(c))
+	if currentUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	org, err := organization.Create(currentUser, body.Name)
+	if err == organization.ErrAlreadyMember {
+		c.JSON(http.StatusConflict, gin.H{"error": "You are already in an organization"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"organization": org})
+}
+
+func GetCurrentOrganization(c *gin.Context) {
+	currentUser := user.GetUserByID(GetUserID(c))
+	if currentUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	org := organization.GetForUser(currentUser)
+	if org == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You are not in an organization"})
+		return
+	}
+
+	members, err := org.Members()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	memberList := []gin.H{}
+	for _, member := range members {
+		memberList = append(memberList, gin.H{
+			"id":    member.ID,
+			"email": member.Email,
+			"role":  member.OrganizationRole,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"organization": org,
+		"members":      memberList,
+	})
+}
+
+// loads the caller's organization and the member addressed by the URL
+// answers the request itself and returns nils when either can't be found
+func loadOrganizationMember(c *gin.Context) (*user.User, *organization.Organization, *user.User) {
+	currentUser := user.GetUserByID(GetUserID(c))
+	if currentUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return nil, nil, nil
+	}
+
+	org := organization.GetForUser(currentUser)
+	if org == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You are not in an organization"})
+		return nil, nil, nil
+	}
+
+	memberID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, nil, nil
+	}
+
+	member := user.GetUserByID(memberID)
+	if member == nil || member.OrganizationID == nil || *member.OrganizationID != org.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return nil, nil, nil
+	}
+
+	return currentUser, org, member
+}
+
+// an organization can't be left without an owner
+func wouldRemoveLastOwner(c *gin.Context, org *organization.Organization, member *user.User) bool {
+	if member.OrganizationRole != user.OrganizationOwner {
+		return false
+	}
+
+	owners, err := org.OwnerCount()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	if owners <= 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "An organization needs at least one owner"})
+		return true
+	}
+
+	return false
+}
+
+func UpdateOrganizationMember(c *gin.Context) {
+	var body organization.UpdateMember
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if _, ok := policy.ORGANIZATION_ROLE_PERMISSIONS[body.Role]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	_, org, member := loadOrganizationMember(c)
+	if member == nil {
+		return
+	}
+
+	if body.Role != user.OrganizationOwner && wouldRemoveLastOwner(c, org, member) {
+		return
+	}
+
+	if err := member.SetOrganization(org.ID, body.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated"})
+}
+
+// owners can remove anyone, everyone can remove themselves i.e. leave
+func RemoveOrganizationMember(c *gin.Context) {
+	currentUser, org, member := loadOrganizationMember(c)
+	if member == nil {
+		return
+	}
+
+	if currentUser.ID != member.ID && !policy.Can(currentUser, policy.ManageOrganization) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can remove members"})
+		return
+	}
+
+	if wouldRemoveLastOwner(c, org, member) {
+		return
+	}
+
+	if err := member.LeaveOrganization(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
@@ -0,0 +1,70 @@
+package api_server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/onetime"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/email"
+	"github.com/gin-gonic/gin"
+)
+
+// always answers the same way so it can't be used to probe for accounts
+func ForgotPassword(c *gin.Context) {
+	var body user.UserForgotPassword
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	existing := user.GetUserByEmail(strings.TrimSpace(body.Email))
+	if existing != nil {
+		token, err := onetime.Issue(existing.ID, onetime.PurposePasswordReset, onetime.PASSWORD_RESET_EXPIRATION_TIME, existing.Email)
+		if err != nil {
+			log.Printf("Failed to issue password reset token: %v", err)
+		} else {
+			email.SendPasswordResetEmail(existing.Email, appLink("/reset-password", token))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the account exists, a reset link has been sent"})
+}
+
+func ResetPassword(c *gin.Context) {
+	var body user.UserResetPassword
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if len(body.NewPassword) < user.MIN_PASSWORD_LENGTH {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", user.MIN_PASSWORD_LENGTH)})
+		return
+	}
+
+	token, err := onetime.Consume(body.Token, onetime.PurposePasswordReset)
+	if err == onetime.ErrInvalidToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resetUser := user.GetUserByID(token.UserID)
+	if resetUser == nil || resetUser.Email != token.Payload {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+		return
+	}
+
+	// also revokes every session, and proving access to the inbox lifts a lockout
+	resetUser.ChangePassword(body.NewPassword)
+	resetLoginThrottle(resetUser.Email)
+	recordAudit(c, audit.Event{Action: audit.ActionPasswordReset, UserID: &resetUser.ID})
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
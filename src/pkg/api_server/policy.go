@@ -0,0 +1,41 @@
+package api_server
+
+import (
+	"net/http"
+
+	"api.lnlink.net/src/pkg/models/policy"
+	"api.lnlink.net/src/pkg/models/user"
+	"github.com/gin-gonic/gin"
+)
+
+// read only requests need the read permission, everything else the write one
+func PolicyMiddleware(read policy.Permission, write policy.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permission := write
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			permission = read
+		}
+
+		if !policy.Can(GetAuthenticatedUser(c), permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to do this"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// for single routes outside of a policy protected group
+func RequirePermission(permission policy.Permission) gin.HandlerFunc {
+	return PolicyMiddleware(permission, permission)
+}
+
+// returns the user loaded by AuthMiddleware, nil on public routes
+func GetAuthenticatedUser(c *gin.Context) *user.User {
+	u, exists := c.Get(CurrentUserKey)
+	if !exists {
+		return nil
+	}
+	return u.(*user.User)
+}
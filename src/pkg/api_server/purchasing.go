@@ -4,6 +4,8 @@ import (
 	"net/http"
 
 	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/apikey"
+	"api.lnlink.net/src/pkg/models/organization"
 	"api.lnlink.net/src/pkg/models/user"
 	"api.lnlink.net/src/pkg/services/stripe"
 	"github.com/gin-gonic/gin"
@@ -14,15 +16,21 @@ func CreateCheckoutSession(c *gin.Context) {
 	user := user.GetUserByID(userID)
 	tokens := c.Param("tokens")
 
+	// purchases by organization members go to the shared wallet
+	customerID := user.StripeCustomerID
+	if org := organization.GetForUser(user); org != nil {
+		customerID = org.StripeCustomerID
+	}
+
 	var checkoutSession string
 	var err error
 	switch tokens {
 	case "5000":
-		checkoutSession, err = stripe.CreateCheckoutSession(user.StripeCustomerID, global.TOKENS_5000_ID)
+		checkoutSession, err = stripe.CreateCheckoutSession(customerID, global.CONFIG.Stripe.Tokens5000ID)
 	case "100":
-		checkoutSession, err = stripe.CreateCheckoutSession(user.StripeCustomerID, global.TOKENS_100_ID)
+		checkoutSession, err = stripe.CreateCheckoutSession(customerID, global.CONFIG.Stripe.Tokens100ID)
 	case "1000":
-		checkoutSession, err = stripe.CreateCheckoutSession(user.StripeCustomerID, global.TOKENS_1000_ID)
+		checkoutSession, err = stripe.CreateCheckoutSession(customerID, global.CONFIG.Stripe.Tokens1000ID)
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid number of tokens"})
 		return
@@ -36,6 +44,7 @@ func CreateCheckoutSession(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"checkoutSession": checkoutSession})
 }
 
-func RegisterPurchasingRoutes(r *gin.Engine) {
-	r.GET("/api/purchasing/checkout/:tokens", AuthMiddleware(), CreateCheckoutSession)
+// r is the /api/purchasing group
+func RegisterPurchasingRoutes(r gin.IRouter) {
+	r.GET("/checkout/:tokens", RequireScope(apikey.ScopePurchasing), CreateCheckoutSession)
 }
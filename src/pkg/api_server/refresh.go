@@ -0,0 +1,78 @@
+package api_server
+
+import (
+	"log"
+	"net/http"
+
+	"api.lnlink.net/src/pkg/models/jwt"
+	"api.lnlink.net/src/pkg/models/refresh"
+	"api.lnlink.net/src/pkg/models/user"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RefreshCookieName = "lnlink_refresh"
+	RefreshCookiePath = "/api/auth"
+)
+
+func setRefreshCookie(c *gin.Context, value string) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(RefreshCookieName, value, int(refresh.DEFAULT_EXPIRATION_TIME.Seconds()), RefreshCookiePath, "", true, true)
+}
+
+func clearRefreshCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(RefreshCookieName, "", -1, RefreshCookiePath, "", true, true)
+}
+
+func RefreshAccessToken(c *gin.Context) {
+	secret, err := c.Cookie(RefreshCookieName)
+	if err != nil || secret == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token required"})
+		return
+	}
+
+	token, next, err := refresh.Rotate(secret)
+	if err == refresh.ErrTokenReused {
+		log.Printf("Refresh token reuse detected, revoked token family")
+		clearRefreshCookie(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token was already used"})
+		return
+	}
+	if err == refresh.ErrInvalidToken {
+		clearRefreshCookie(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	currentUser := user.GetUserByID(token.UserID)
+	if currentUser == nil || currentUser.PendingVerification {
+		clearRefreshCookie(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	accessToken, err := jwt.CreateJWT(currentUser.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the session follows the refresh token family, if it was revoked the family goes too
+	if !currentUser.RotateSession(token.FamilyID, accessToken.Claims.JWTID, c.ClientIP(), c.Request.UserAgent()) {
+		if err := refresh.RevokeFamily(token.FamilyID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		clearRefreshCookie(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session was revoked"})
+		return
+	}
+	setRefreshCookie(c, next)
+
+	c.JSON(http.StatusOK, gin.H{"accessToken": accessToken.Value})
+}
@@ -0,0 +1,123 @@
+package api_server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/onetime"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/email"
+	"github.com/gin-gonic/gin"
+)
+
+// builds a link into the web app carrying a one time token
+func appLink(path string, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", strings.TrimRight(global.CONFIG.URLs.App, "/"), path, url.QueryEscape(token))
+}
+
+// issues a verification token and emails the link to the user
+func sendVerificationEmail(u *user.User) error {
+	token, err := onetime.Issue(u.ID, onetime.PurposeEmailVerification, onetime.EMAIL_VERIFICATION_EXPIRATION_TIME, u.Email)
+	if err != nil {
+		return err
+	}
+
+	email.SendVerificationEmail(u.Email, appLink("/verify-email", token))
+	return nil
+}
+
+func RegisterUser(c *gin.Context) {
+	var userAuth user.UserAuth
+	if err := c.ShouldBindJSON(&userAuth); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	userAuth.Email = strings.TrimSpace(userAuth.Email)
+	if _, err := mail.ParseAddress(userAuth.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+		return
+	}
+
+	if len(userAuth.Password) < user.MIN_PASSWORD_LENGTH {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be at least %d characters", user.MIN_PASSWORD_LENGTH)})
+		return
+	}
+
+	newUser, err := user.RegisterUser(&userAuth)
+	if err == user.ErrEmailTaken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already registered"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to register user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+		return
+	}
+
+	recordAudit(c, audit.Event{Action: audit.ActionRegistered, UserID: &newUser.ID})
+
+	if err := sendVerificationEmail(newUser); err != nil {
+		log.Printf("Failed to issue verification token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Check your inbox to verify your email address"})
+}
+
+func VerifyEmail(c *gin.Context) {
+	var body user.UserVerifyEmail
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	token, err := onetime.Consume(body.Token, onetime.PurposeEmailVerification)
+	if err == onetime.ErrInvalidToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	verifiedUser := user.GetUserByID(token.UserID)
+	if verifiedUser == nil || verifiedUser.Email != token.Payload {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+
+	if err := verifiedUser.MarkVerified(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit.Event{Action: audit.ActionEmailVerified, UserID: &verifiedUser.ID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// always answers the same way so it can't be used to probe for accounts
+func ResendVerificationEmail(c *gin.Context) {
+	var body user.UserResendVerification
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	existing := user.GetUserByEmail(strings.TrimSpace(body.Email))
+	if existing != nil && existing.PendingVerification {
+		if err := sendVerificationEmail(existing); err != nil {
+			log.Printf("Failed to issue verification token: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the account exists and is unverified, a new link has been sent"})
+}
@@ -1,12 +1,34 @@
 package api_server
 
 import (
+	"api.lnlink.net/src/pkg/models/policy"
 	"github.com/gin-gonic/gin"
 )
 
 func RegisterAllRoutes(r *gin.Engine) {
 	RegisterAuthRoutes(r)
-	RegisterPurchasingRoutes(r)
+	RegisterSessionRoutes(r)
+	RegisterAPIKeyRoutes(r)
+	RegisterTwoFactorRoutes(r)
+	RegisterSSORoutes(r)
+	RegisterInvitationRoutes(r)
+	RegisterAccountDataRoutes(r)
+	RegisterAuditRoutes(r)
 	RegisterWebhookRoutes(r)
-	RegisterExperimentRoutes(r)
+	RegisterJWKSRoutes(r)
+	RegisterFeatureRoutes(r)
+	RegisterStorageRoutes(r)
+
+	// organization handlers check permissions per member they touch
+	RegisterOrganizationRoutes(r.Group("/api/organizations", AuthMiddleware()))
+
+	RegisterPurchasingRoutes(r.Group("/api/purchasing", RequireFeature(FeaturePurchasing),
+		AuthMiddleware(), PolicyMiddleware(policy.PurchaseTokens, policy.PurchaseTokens)))
+
+	// viewers can browse results and downloads but not submit
+	RegisterExperimentRoutes(r.Group("/api/experiments", RequireFeature(FeatureExperiments),
+		AuthMiddleware(), PolicyMiddleware(policy.ViewExperiments, policy.SubmitExperiments)))
+
+	RegisterAdminRoutes(r.Group("/api/admin",
+		AuthMiddleware(), PolicyMiddleware(policy.Administer, policy.Administer)))
 }
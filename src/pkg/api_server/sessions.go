@@ -0,0 +1,84 @@
+package api_server
+
+import (
+	"net/http"
+
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/user"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterSessionRoutes(r *gin.Engine) {
+	r.GET("/api/auth/sessions", AuthMiddleware(), RequireSession(), ListSessions)
+	r.DELETE("/api/auth/sessions", AuthMiddleware(), RequireSession(), RevokeOtherSessions)
+	r.DELETE("/api/auth/sessions/:id", AuthMiddleware(), RequireSession(), RevokeSession)
+}
+
+func ListSessions(c *gin.Context) {
+	currentUser := user.GetUserByID(GetUserID(c))
+	if currentUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	currentSessionID := GetSessionID(c)
+	sessions := []gin.H{}
+	for _, session := range currentUser.Sessions {
+		if currentUser.GetSession(session.ID) == nil {
+			continue
+		}
+		sessions = append(sessions, gin.H{
+			"id":         session.ID,
+			"createdAt":  session.CreatedAt,
+			"lastSeenAt": session.LastSeenAt,
+			"expiresAt":  session.ExpiresAt,
+			"ip":         session.IP,
+			"userAgent":  session.UserAgent,
+			"current":    session.ID == currentSessionID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+func RevokeSession(c *gin.Context) {
+	currentUser := user.GetUserByID(GetUserID(c))
+	if currentUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	sessionID := c.Param("id")
+	removed, err := currentUser.RemoveSession(sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if sessionID == GetSessionID(c) {
+		clearRefreshCookie(c)
+	}
+	recordAudit(c, audit.Event{Action: audit.ActionSessionRevoked, UserID: &currentUser.ID, Details: map[string]any{"sessionId": sessionID}})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+func RevokeOtherSessions(c *gin.Context) {
+	currentUser := user.GetUserByID(GetUserID(c))
+	if currentUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	revoked, err := currentUser.RemoveOtherSessions(GetSessionID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit.Event{Action: audit.ActionSessionRevoked, UserID: &currentUser.ID, Details: map[string]any{"revoked": revoked}})
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked", "revoked": revoked})
+}
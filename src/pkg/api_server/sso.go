@@ -0,0 +1,229 @@
+package api_server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/onetime"
+	"api.lnlink.net/src/pkg/models/organization"
+	"api.lnlink.net/src/pkg/models/sso"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/oidc"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterSSORoutes(r *gin.Engine) {
+	r.GET("/api/auth/sso/discover", DiscoverSSOProvider)
+	r.GET("/api/auth/sso/:provider/start", StartSSOLogin)
+	r.GET("/api/auth/sso/:provider/callback", SSOCallback)
+	r.POST("/api/auth/sso/exchange", ExchangeSSOLogin)
+}
+
+func ssoRedirectURI(provider *sso.Provider) string {
+	return fmt.Sprintf("%s/api/auth/sso/%s/callback", strings.TrimRight(global.CONFIG.URLs.API, "/"), url.PathEscape(provider.Slug))
+}
+
+// sends the browser back to the web app with an error code it can display
+func ssoFail(c *gin.Context, reason string) {
+	link := fmt.Sprintf("%s/sso/callback?error=%s", strings.TrimRight(global.CONFIG.URLs.App, "/"), url.QueryEscape(reason))
+	c.Redirect(http.StatusFound, link)
+}
+
+// tells the web app whether an email should sign in through a provider
+func DiscoverSSOProvider(c *gin.Context) {
+	provider, err := sso.GetProviderForEmail(strings.TrimSpace(c.Query("email")))
+	if err == sso.ErrProviderNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No SSO provider for this email"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"provider": gin.H{"slug": provider.Slug, "name": provider.Name}})
+}
+
+func StartSSOLogin(c *gin.Context) {
+	provider, err := sso.GetProviderBySlug(c.Param("provider"))
+	if err == sso.ErrProviderNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SSO provider not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	discovery, err := oidc.Discover(provider.Issuer)
+	if err != nil {
+		log.Printf("[SSO] %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "SSO provider is unavailable"})
+		return
+	}
+
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	state, err := oidc.NewNonce()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	nonce, err := oidc.NewNonce()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = sso.CreateLoginState(&sso.LoginState{
+		State:        state,
+		ProviderSlug: provider.Slug,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	authURL := oidc.AuthorizationURL(discovery, provider.ClientID, ssoRedirectURI(provider), provider.GetScopes(), state, nonce, challenge, c.Query("email"))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// finds the account for a verified identity, linking or creating it when allowed
+func resolveSSOUser(provider *sso.Provider, claims *oidc.IDTokenClaims) (*user.User, string) {
+	if linked := user.GetUserBySSOIdentity(provider.Slug, claims.Subject); linked != nil {
+		return linked, ""
+	}
+
+	// emails are only trusted when the provider vouches for them and owns the domain
+	if claims.Email == "" || !claims.EmailVerified || !provider.OwnsEmail(claims.Email) {
+		return nil, "email_not_verified"
+	}
+
+	if existing := user.GetUserByEmail(claims.Email); existing != nil {
+		if err := existing.LinkSSOIdentity(provider.Slug, claims.Subject); err != nil {
+			log.Printf("[SSO] %v", err)
+			return nil, "server_error"
+		}
+		if existing.PendingVerification {
+			if err := existing.MarkVerified(); err != nil {
+				log.Printf("[SSO] %v", err)
+				return nil, "server_error"
+			}
+		}
+		return existing, ""
+	}
+
+	if !provider.AutoProvision {
+		return nil, "no_account"
+	}
+
+	created, err := user.CreateSSOUser(claims.Email, provider.Slug, claims.Subject)
+	if err != nil {
+		log.Printf("[SSO] %v", err)
+		return nil, "server_error"
+	}
+
+	// the provider signs in for its organization, so its new accounts start out in it
+	if !provider.OrganizationID.IsZero() && organization.GetByID(provider.OrganizationID) != nil {
+		if err := created.SetOrganization(provider.OrganizationID, user.OrganizationMember); err != nil {
+			log.Printf("[SSO] %v", err)
+			return nil, "server_error"
+		}
+	}
+	return created, ""
+}
+
+func SSOCallback(c *gin.Context) {
+	if reason := c.Query("error"); reason != "" {
+		ssoFail(c, reason)
+		return
+	}
+
+	loginState, err := sso.ConsumeLoginState(c.Query("state"))
+	if err != nil || loginState.ProviderSlug != c.Param("provider") {
+		ssoFail(c, "invalid_state")
+		return
+	}
+
+	provider, err := sso.GetProviderBySlug(loginState.ProviderSlug)
+	if err != nil {
+		ssoFail(c, "provider_not_found")
+		return
+	}
+
+	discovery, err := oidc.Discover(provider.Issuer)
+	if err != nil {
+		log.Printf("[SSO] %v", err)
+		ssoFail(c, "provider_unavailable")
+		return
+	}
+
+	tokens, err := oidc.ExchangeCode(discovery, provider.ClientID, provider.ClientSecret, ssoRedirectURI(provider), c.Query("code"), loginState.CodeVerifier)
+	if err != nil {
+		log.Printf("[SSO] Code exchange with %s failed: %v", provider.Slug, err)
+		ssoFail(c, "exchange_failed")
+		return
+	}
+
+	claims, err := oidc.VerifyIDToken(discovery, provider.ClientID, tokens.IDToken, loginState.Nonce)
+	if err != nil {
+		log.Printf("[SSO] ID token from %s rejected: %v", provider.Slug, err)
+		ssoFail(c, "invalid_id_token")
+		return
+	}
+
+	ssoUser, reason := resolveSSOUser(provider, claims)
+	if ssoUser == nil {
+		ssoFail(c, reason)
+		return
+	}
+
+	// the web app trades this for an access token, so no token ends up in a URL
+	loginToken, err := onetime.Issue(ssoUser.ID, onetime.PurposeSSOLogin, onetime.SSO_LOGIN_EXPIRATION_TIME, provider.Slug)
+	if err != nil {
+		ssoFail(c, "server_error")
+		return
+	}
+
+	c.Redirect(http.StatusFound, appLink("/sso/callback", loginToken))
+}
+
+func ExchangeSSOLogin(c *gin.Context) {
+	var body user.UserSSOExchange
+	if err := c.ShouldBindJSON(&body); err != nil || body.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	token, err := onetime.Consume(body.Token, onetime.PurposeSSOLogin)
+	if err == onetime.ErrInvalidToken {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ssoUser := user.GetUserByID(token.UserID)
+	if ssoUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	if ssoUser.HasTwoFactor() {
+		startTwoFactorChallenge(c, ssoUser)
+		return
+	}
+
+	completeLogin(c, ssoUser)
+}
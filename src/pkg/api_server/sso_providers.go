@@ -0,0 +1,152 @@
+package api_server
+
+import (
+	"errors"
+	"net/http"
+
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/sso"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// r is the /api/admin group, providers are set up by platform admins for an organization
+func RegisterSSOProviderRoutes(r gin.IRouter) {
+	r.GET("/organizations/:organizationId/sso-providers", RequireSession(), ListSSOProviders)
+	r.POST("/organizations/:organizationId/sso-providers", RequireSession(), CreateSSOProvider)
+	r.PATCH("/organizations/:organizationId/sso-providers/:providerId", RequireSession(), UpdateSSOProvider)
+	r.POST("/organizations/:organizationId/sso-providers/:providerId/disable", RequireSession(), DisableSSOProvider)
+	r.POST("/organizations/:organizationId/sso-providers/:providerId/enable", RequireSession(), EnableSSOProvider)
+}
+
+// loads the provider named in the path, it has to belong to the organization in the path
+func loadSSOProvider(c *gin.Context) *sso.Provider {
+	org := loadAdminOrganization(c)
+	if org == nil {
+		return nil
+	}
+
+	providerID, err := primitive.ObjectIDFromHex(c.Param("providerId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return nil
+	}
+
+	provider, err := sso.GetProvider(org.ID, providerID)
+	if err == sso.ErrProviderNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SSO provider not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return provider
+}
+
+// answers for an error from storing a provider
+func ssoProviderError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, sso.ErrInvalidProvider):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err == sso.ErrProviderTaken:
+		c.JSON(http.StatusConflict, gin.H{"error": "Slug or domain is already used by another SSO provider"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func recordSSOProviderAudit(c *gin.Context, action audit.Action, provider *sso.Provider) {
+	recordAudit(c, audit.Event{
+		Action:         action,
+		OrganizationID: &provider.OrganizationID,
+		Details:        map[string]any{"providerId": provider.ID, "slug": provider.Slug, "domains": provider.Domains},
+	})
+}
+
+func ListSSOProviders(c *gin.Context) {
+	org := loadAdminOrganization(c)
+	if org == nil {
+		return
+	}
+
+	providers, err := sso.ListProviders(&org.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get SSO providers"})
+		return
+	}
+
+	views := []map[string]any{}
+	for i := range providers {
+		views = append(views, providers[i].AdminView())
+	}
+	c.JSON(http.StatusOK, gin.H{"providers": views})
+}
+
+func CreateSSOProvider(c *gin.Context) {
+	var body sso.CreateProvider
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	org := loadAdminOrganization(c)
+	if org == nil {
+		return
+	}
+
+	provider := sso.NewProvider(body)
+	provider.OrganizationID = org.ID
+	if err := provider.Insert(); err != nil {
+		ssoProviderError(c, err)
+		return
+	}
+
+	recordSSOProviderAudit(c, audit.ActionSSOProviderCreated, provider)
+	c.JSON(http.StatusCreated, gin.H{"provider": provider.AdminView()})
+}
+
+func UpdateSSOProvider(c *gin.Context) {
+	var body sso.UpdateProvider
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	provider := loadSSOProvider(c)
+	if provider == nil {
+		return
+	}
+
+	if err := provider.Update(body); err != nil {
+		ssoProviderError(c, err)
+		return
+	}
+
+	recordSSOProviderAudit(c, audit.ActionSSOProviderUpdated, provider)
+	c.JSON(http.StatusOK, gin.H{"provider": provider.AdminView()})
+}
+
+// stops new sign ins through the provider, linked accounts can still use their password
+func DisableSSOProvider(c *gin.Context) {
+	setSSOProviderEnabled(c, false, audit.ActionSSOProviderDisabled)
+}
+
+func EnableSSOProvider(c *gin.Context) {
+	setSSOProviderEnabled(c, true, audit.ActionSSOProviderEnabled)
+}
+
+func setSSOProviderEnabled(c *gin.Context, enabled bool, action audit.Action) {
+	provider := loadSSOProvider(c)
+	if provider == nil {
+		return
+	}
+
+	if err := provider.SetEnabled(enabled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordSSOProviderAudit(c, action, provider)
+	c.JSON(http.StatusOK, gin.H{"provider": provider.AdminView()})
+}
@@ -0,0 +1,45 @@
+package api_server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"api.lnlink.net/src/pkg/services/storage"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterStorageRoutes(r *gin.Engine) {
+	r.GET("/api/storage/:bucket/*key", DownloadStoredObject)
+}
+
+// serves the presigned links of the local storage backend, S3 links never point here
+func DownloadStoredObject(c *gin.Context) {
+	local, ok := storage.Client().(*storage.Local)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+
+	bucket := c.Param("bucket")
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if err := local.Verify(bucket, key, c.Query("expires"), c.Query("signature")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	filePath, err := local.Path(bucket, key)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	if info, err := os.Stat(filePath); err != nil || info.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(key)))
+	c.File(filePath)
+}
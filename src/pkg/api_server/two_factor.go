@@ -0,0 +1,178 @@
+package api_server
+
+import (
+	"net/http"
+
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/onetime"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/totp"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterTwoFactorRoutes(r *gin.Engine) {
+	r.POST("/api/auth/login/2fa", LoginTwoFactor)
+	r.POST("/api/auth/2fa/enroll", AuthMiddleware(), RequireSession(), EnrollTwoFactor)
+	r.POST("/api/auth/2fa/confirm", AuthMiddleware(), RequireSession(), ConfirmTwoFactor)
+	r.DELETE("/api/auth/2fa", AuthMiddleware(), RequireSession(), DisableTwoFactor)
+}
+
+// issues a short lived challenge after the password check, the JWT is only issued once
+// the challenge is answered with a code in LoginTwoFactor
+func startTwoFactorChallenge(c *gin.Context, u *user.User) {
+	challenge, err := onetime.Issue(u.ID, onetime.PurposeLoginChallenge, onetime.LOGIN_CHALLENGE_EXPIRATION_TIME, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"twoFactorRequired": true,
+		"challengeToken":    challenge,
+	})
+}
+
+func LoginTwoFactor(c *gin.Context) {
+	var body user.UserLoginTwoFactor
+	if err := c.ShouldBindJSON(&body); err != nil || body.ChallengeToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	challenge, err := onetime.Lookup(body.ChallengeToken, onetime.PurposeLoginChallenge)
+	if err == onetime.ErrInvalidToken {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	loginUser := user.GetUserByID(challenge.UserID)
+	if loginUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !checkLoginThrottle(c, loginUser.Email) {
+		return
+	}
+
+	if !loginUser.VerifyTwoFactor(body.Code) {
+		recordLoginFailure(c, loginUser.Email)
+		recordLoginFailureAudit(c, loginUser.Email, "invalid two factor code")
+		if err := challenge.RecordFailedAttempt(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two factor code"})
+		return
+	}
+
+	// consuming is what makes the challenge single use
+	if _, err := onetime.Consume(body.ChallengeToken, onetime.PurposeLoginChallenge); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+		return
+	}
+
+	completeLogin(c, loginUser)
+}
+
+func EnrollTwoFactor(c *gin.Context) {
+	currentUser := user.GetUserByID(GetUserID(c))
+	if currentUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	secret, err := currentUser.BeginTwoFactorEnrollment()
+	if err == user.ErrTwoFactorEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two factor authentication is already enabled"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":          secret,
+		"provisioningUri": totp.ProvisioningURI(secret, currentUser.Email, totp.DEFAULT_ISSUER),
+	})
+}
+
+func ConfirmTwoFactor(c *gin.Context) {
+	var body user.UserTwoFactorCode
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	currentUser := user.GetUserByID(GetUserID(c))
+	if currentUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	recoveryCodes, err := currentUser.ConfirmTwoFactor(body.Code)
+	switch err {
+	case nil:
+	case user.ErrInvalidCode:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two factor code"})
+		return
+	case user.ErrTwoFactorEnabled:
+		c.JSON(http.StatusConflict, gin.H{"error": "Two factor authentication is already enabled"})
+		return
+	case user.ErrTwoFactorNotEnrolled:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two factor enrollment wasn't started"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, audit.Event{Action: audit.ActionTwoFactorEnabled, UserID: &currentUser.ID})
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": recoveryCodes})
+}
+
+func DisableTwoFactor(c *gin.Context) {
+	var body user.UserDisableTwoFactor
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	currentUser := user.GetUserByID(GetUserID(c))
+	if currentUser == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	success, currentUser := user.AuthenticateUser(&user.UserAuth{
+		Email:    currentUser.Email,
+		Password: body.Password,
+	})
+	if !success {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		return
+	}
+
+	if !currentUser.HasTwoFactor() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two factor authentication is not enabled"})
+		return
+	}
+
+	if !currentUser.VerifyTwoFactor(body.Code) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two factor code"})
+		return
+	}
+
+	if err := currentUser.DisableTwoFactor(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, audit.Event{Action: audit.ActionTwoFactorDisabled, UserID: &currentUser.ID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two factor authentication disabled"})
+}
@@ -5,14 +5,17 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
 
 	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/organization"
 	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/pipeline"
 	"github.com/gin-gonic/gin"
 	"github.com/stripe/stripe-go/v81"
 	"github.com/stripe/stripe-go/v81/checkout/session"
 	"github.com/stripe/stripe-go/v81/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func WebhookHandler(c *gin.Context) {
@@ -25,13 +28,7 @@ func WebhookHandler(c *gin.Context) {
 	}
 
 	sigHeader := c.Request.Header.Get("Stripe-Signature")
-	endpointSecret := os.Getenv("STRIPE_WEBHOOK_SECRET")
-	if endpointSecret == "" {
-		c.String(http.StatusInternalServerError, "Webhook secret is not configured")
-		return
-	}
-
-	event, err := webhook.ConstructEvent(payload, sigHeader, endpointSecret)
+	event, err := webhook.ConstructEvent(payload, sigHeader, global.CONFIG.Stripe.WebhookSecret)
 	if err != nil {
 		log.Printf("Error verifying webhook signature: %v\n", err)
 		c.String(http.StatusBadRequest, "Webhook signature verification failed")
@@ -54,9 +51,17 @@ func WebhookHandler(c *gin.Context) {
 			return
 		}
 
-		customerUser := user.GetUserByStripeCustomerID(customerID)
-		if customerUser == nil {
-			log.Printf("No user found for customer ID: %s\n", customerID)
+		// the customer is either a user's personal one or an organization's
+		var creditPurchase func(checkoutSessionID string, tokens int) (bool, error)
+		purchaseEvent := audit.Event{Action: audit.ActionTokensPurchased}
+		if customerUser := user.GetUserByStripeCustomerID(customerID); customerUser != nil {
+			creditPurchase = customerUser.CreditPurchase
+			purchaseEvent.UserID = &customerUser.ID
+		} else if customerOrg := organization.GetByStripeCustomerID(customerID); customerOrg != nil {
+			creditPurchase = customerOrg.CreditPurchase
+			purchaseEvent.OrganizationID = &customerOrg.ID
+		} else {
+			log.Printf("No user or organization found for customer ID: %s\n", customerID)
 			c.String(http.StatusBadRequest, "No user found for customer ID")
 			return
 		}
@@ -73,17 +78,39 @@ func WebhookHandler(c *gin.Context) {
 			return
 		}
 
+		purchased := 0
 		for _, lineItem := range s.LineItems.Data {
 			id := lineItem.Price.ID
-			if id == global.TOKENS_5000_ID {
-				customerUser.AddTokens(5000)
-			} else if id == global.TOKENS_100_ID {
-				customerUser.AddTokens(100)
-			} else if id == global.TOKENS_1000_ID {
-				customerUser.AddTokens(1000)
+			if id == global.CONFIG.Stripe.Tokens5000ID {
+				purchased += 5000
+			} else if id == global.CONFIG.Stripe.Tokens100ID {
+				purchased += 100
+			} else if id == global.CONFIG.Stripe.Tokens1000ID {
+				purchased += 1000
 			}
 		}
 
+		// stripe retries until it gets a 2xx, crediting once per session makes that safe
+		credited, err := creditPurchase(stripeSession.ID, purchased)
+		if err != nil {
+			log.Printf("Error crediting checkout session %s: %v\n", stripeSession.ID, err)
+			c.String(http.StatusInternalServerError, "Error crediting tokens")
+			return
+		}
+		if !credited {
+			log.Printf("Checkout session %s was already credited\n", stripeSession.ID)
+			break
+		}
+
+		purchaseEvent.Details = map[string]any{
+			"tokens":            purchased,
+			"checkoutSessionId": stripeSession.ID,
+			"customerId":        customerID,
+			"amountTotal":       s.AmountTotal,
+			"currency":          s.Currency,
+		}
+		recordAudit(c, purchaseEvent)
+
 	default:
 		log.Printf("Unhandled event type: %s\n", event.Type)
 	}
@@ -93,5 +120,44 @@ func WebhookHandler(c *gin.Context) {
 }
 
 func RegisterWebhookRoutes(r *gin.Engine) {
-	r.POST("/api/webhooks/stripe", WebhookHandler)
+	r.POST("/api/webhooks/stripe", RequireFeature(FeaturePurchasing), WebhookHandler)
+	r.POST("/api/webhooks/inference", RequireFeature(FeatureSubmissions), InferenceWebhookHandler)
+}
+
+// the inference backend reports a finished job here, the signature in the
+// URL proves we handed it out for exactly this image
+func InferenceWebhookHandler(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Query("group"))
+	fileID := c.Query("file")
+	if err != nil || !pipeline.VerifyCallback(groupID, fileID, c.Query("signature")) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid callback signature"})
+		return
+	}
+
+	// the payload can include the job output, which isn't needed here
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 1<<20)
+	var callback pipeline.Callback
+	if err := c.ShouldBindJSON(&callback); err != nil || callback.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	err = pipeline.HandleCallback(groupID, fileID, callback.ID)
+	if err == pipeline.ErrClaimed {
+		// another replica is on it right now, the backend retries
+		c.JSON(http.StatusConflict, gin.H{"error": "Experiment is being processed"})
+		return
+	}
+	if err == pipeline.ErrUnknownExperiment {
+		// the group might not be stored yet, the backend retries
+		c.JSON(http.StatusNotFound, gin.H{"error": "Experiment not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("[InferenceWebhook] Error handling callback for job %s: %v", callback.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to handle callback"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ok"})
 }
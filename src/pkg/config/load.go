@@ -0,0 +1,174 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// where docker and kubernetes mount secrets by default
+var DEFAULT_SECRETS_DIR = "/run/secrets"
+
+// Load reads the configuration, later sources win:
+//  1. the defaults on the struct tags
+//  2. the JSON file named by CONFIG_FILE
+//  3. a .env file in the working directory, if there is one
+//  4. environment variables, or for those that aren't set a secret file,
+//     either named by <NAME>_FILE or mounted as <NAME> in SECRETS_DIR
+//
+// a variable that is set wins over its secret file, so a deploy can override a mounted secret
+//
+// the returned error is Problems and lists everything that is wrong at once
+func Load() (*Config, error) {
+	problems := Problems{}
+	cfg := &Config{}
+
+	eachSetting(cfg, func(field reflect.StructField, value reflect.Value) {
+		if def, ok := field.Tag.Lookup("default"); ok {
+			if err := setValue(value, def); err != nil {
+				problems.Add("invalid default for %s: %v", field.Tag.Get("env"), err)
+			}
+		}
+	})
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := loadFile(cfg, path); err != nil {
+			problems.Add("CONFIG_FILE: %v", err)
+		}
+	}
+
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		problems.Add(".env: %v", err)
+	}
+
+	secretsDir := os.Getenv("SECRETS_DIR")
+	if secretsDir == "" {
+		secretsDir = DEFAULT_SECRETS_DIR
+	}
+
+	eachSetting(cfg, func(field reflect.StructField, value reflect.Value) {
+		name := field.Tag.Get("env")
+		raw, found, err := lookup(name, secretsDir)
+		if err != nil {
+			problems.Add("%s: %v", name, err)
+			return
+		}
+		if !found {
+			return
+		}
+		if err := setValue(value, raw); err != nil {
+			problems.Add("%s: %v", name, err)
+		}
+	})
+
+	problems = append(problems, cfg.Validate()...)
+	if len(problems) > 0 {
+		return cfg, problems
+	}
+	return cfg, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// looks the setting up in the environment first, then in secret files
+func lookup(name string, secretsDir string) (string, bool, error) {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value, true, nil
+	}
+
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		value, err := readSecret(path)
+		if err != nil {
+			return "", false, err
+		}
+		return value, true, nil
+	}
+
+	for _, fileName := range []string{name, strings.ToLower(name)} {
+		path := filepath.Join(secretsDir, fileName)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		value, err := readSecret(path)
+		if err != nil {
+			return "", false, err
+		}
+		return value, true, nil
+	}
+
+	return "", false, nil
+}
+
+// secret files usually end in a newline that isn't part of the secret
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("can't read secret file: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// calls fn for every field of every section that has an env tag
+func eachSetting(cfg *Config, fn func(field reflect.StructField, value reflect.Value)) {
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			if _, ok := field.Tag.Lookup("env"); ok {
+				fn(field, section.Field(j))
+			}
+		}
+	}
+}
+
+// lists are comma separated
+func setValue(value reflect.Value, raw string) error {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		enabled, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		value.SetBool(enabled)
+	case reflect.Int:
+		number, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		value.SetInt(int64(number))
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", value.Kind())
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package config
+
+import "time"
+
+// every setting lives in a section, `env` is the variable it is read from,
+// `default` what it falls back to and `json` its key in the config file
+type Config struct {
+	Server    ServerConfig    `json:"server"`
+	Mongo     MongoConfig     `json:"mongo"`
+	URLs      URLConfig       `json:"urls"`
+	JWT       JWTConfig       `json:"jwt"`
+	Audit     AuditConfig     `json:"audit"`
+	Email     EmailConfig     `json:"email"`
+	Stripe    StripeConfig    `json:"stripe"`
+	Inference InferenceConfig `json:"inference"`
+	RunPod    RunPodConfig    `json:"runpod"`
+	Storage   StorageConfig   `json:"storage"`
+	S3        S3Config        `json:"s3"`
+}
+
+type ServerConfig struct {
+	// IPs or CIDR ranges of the proxies in front of the server, only their
+	// X-Forwarded-For headers are believed. empty trusts none, so the client
+	// IP is the address of the connection
+	TrustedProxies []string `json:"trustedProxies" env:"TRUSTED_PROXIES"`
+	// a header the platform sets to the client IP, e.g. CF-Connecting-IP,
+	// takes precedence over TrustedProxies
+	TrustedPlatform string `json:"trustedPlatform" env:"TRUSTED_PLATFORM"`
+}
+
+type MongoConfig struct {
+	URI      string `json:"uri" env:"MONGO_DB_URI"`
+	Database string `json:"database" env:"MONGO_DB_NAME"`
+}
+
+type URLConfig struct {
+	// the frontend, links in emails point here
+	App string `json:"app" env:"APP_URL"`
+	// where this server is reachable from the outside
+	API string `json:"api" env:"API_URL"`
+}
+
+type JWTConfig struct {
+	SigningKeyFile string `json:"signingKeyFile" env:"JWT_SIGNING_KEY_FILE"`
+	// keys that still verify tokens after a rotation
+	VerificationKeyFiles []string `json:"verificationKeyFiles" env:"JWT_VERIFICATION_KEY_FILES"`
+}
+
+type AuditConfig struct {
+	RetentionDays int `json:"retentionDays" env:"AUDIT_RETENTION_DAYS" default:"365"`
+}
+
+// optional, without it emails are written to the log
+type EmailConfig struct {
+	APIKey string `json:"apiKey" env:"RESEND_API_KEY"`
+	From   string `json:"from" env:"RESEND_FROM"`
+	// development only, also logs the bodies of unsent emails. they carry
+	// login, reset and verification links
+	LogBodies bool `json:"logBodies" env:"EMAIL_LOG_BODIES"`
+}
+
+// optional, without it users have no billing and can't purchase tokens
+type StripeConfig struct {
+	SecretKey string `json:"secretKey" env:"STRIPE_SECRET_KEY"`
+	// verifies the events stripe sends to /api/webhooks/stripe
+	WebhookSecret string `json:"webhookSecret" env:"STRIPE_WEBHOOK_SECRET"`
+	// where checkout returns to, defaults to the app
+	SuccessURL   string `json:"successUrl" env:"SUCCESS_URL"`
+	Tokens100ID  string `json:"tokens100Id" env:"TOKENS_100_ID"`
+	Tokens1000ID string `json:"tokens1000Id" env:"TOKENS_1000_ID"`
+	Tokens5000ID string `json:"tokens5000Id" env:"TOKENS_5000_ID"`
+}
+
+// optional, without it experiments can't be submitted
+type InferenceConfig struct {
+	// "runpod" or "local", defaults to runpod when it is configured
+	Backend string `json:"backend" env:"INFERENCE_BACKEND"`
+	// the local backend runs this for every image, see inference.Local,
+	// when empty it fakes results after FakeSeconds
+	LocalCommand string `json:"localCommand" env:"INFERENCE_LOCAL_COMMAND"`
+	FakeSeconds  int    `json:"fakeSeconds" env:"INFERENCE_FAKE_SECONDS" default:"5"`
+	// signs the callback URL sent with every submission, without it
+	// results are only picked up by polling every PollSeconds
+	CallbackSecret string `json:"callbackSecret" env:"INFERENCE_CALLBACK_SECRET"`
+	PollSeconds    int    `json:"pollSeconds" env:"INFERENCE_POLL_SECONDS" default:"15"`
+	// with callbacks polling only catches the ones that got lost
+	ReconcileSeconds int `json:"reconcileSeconds" env:"INFERENCE_RECONCILE_SECONDS" default:"300"`
+}
+
+const (
+	InferenceRunPod = "runpod"
+	InferenceLocal  = "local"
+)
+
+type RunPodConfig struct {
+	APIKey     string `json:"apiKey" env:"RUNPOD_API_KEY"`
+	EndpointID string `json:"endpointId" env:"RUNPOD_ENDPOINT_ID"`
+	BaseURL    string `json:"baseUrl" env:"RUNPOD_BASE_URL" default:"https://api.runpod.ai/v2"`
+}
+
+// optional, without it there is nowhere to keep images, results and exports
+type StorageConfig struct {
+	// "s3" or "local", defaults to s3 when it is configured
+	Backend      string `json:"backend" env:"STORAGE_BACKEND"`
+	InputBucket  string `json:"inputBucket" env:"S3_INPUT_BUCKET_NAME" default:"input"`
+	OutputBucket string `json:"outputBucket" env:"S3_OUTPUT_BUCKET_NAME" default:"output"`
+	ModelBucket  string `json:"modelBucket" env:"S3_MODEL_BUCKET_NAME"`
+	// the local backend keeps every bucket as a directory in here
+	LocalDir string `json:"localDir" env:"STORAGE_LOCAL_DIR" default:"storage"`
+	// signs local download links, a random key is used when empty
+	// so links stop working on restart
+	SigningKey string `json:"signingKey" env:"STORAGE_SIGNING_KEY"`
+}
+
+const (
+	StorageS3    = "s3"
+	StorageLocal = "local"
+)
+
+// also works with S3 compatible stores like MinIO or Ceph through Endpoint
+type S3Config struct {
+	Region string `json:"region" env:"S3_REGION"`
+	// without keys the default AWS credential chain is used, e.g. an instance role
+	AccessKeyID     string `json:"accessKeyId" env:"S3_ACCESS_KEY_ID"`
+	SecretAccessKey string `json:"secretAccessKey" env:"S3_SECRET_ACCESS_KEY"`
+	Endpoint        string `json:"endpoint" env:"S3_ENDPOINT"`
+	// most self hosted stores need bucket/key paths instead of bucket subdomains
+	ForcePathStyle bool `json:"forcePathStyle" env:"S3_FORCE_PATH_STYLE"`
+}
+
+func (c EmailConfig) Enabled() bool {
+	return c.APIKey != ""
+}
+
+func (c StripeConfig) Enabled() bool {
+	return c.SecretKey != ""
+}
+
+func (c InferenceConfig) Enabled() bool {
+	return c.Backend != ""
+}
+
+func (c InferenceConfig) CallbacksEnabled() bool {
+	return c.CallbackSecret != ""
+}
+
+// how often in-progress jobs are checked at the backend
+func (c InferenceConfig) PollInterval() time.Duration {
+	if c.CallbacksEnabled() {
+		return time.Duration(c.ReconcileSeconds) * time.Second
+	}
+	return time.Duration(c.PollSeconds) * time.Second
+}
+
+func (c RunPodConfig) Configured() bool {
+	return c.APIKey != "" || c.EndpointID != ""
+}
+
+func (c StorageConfig) Enabled() bool {
+	return c.Backend != ""
+}
+
+func (c S3Config) Configured() bool {
+	return c.Region != "" || c.AccessKeyID != "" || c.SecretAccessKey != "" || c.Endpoint != ""
+}
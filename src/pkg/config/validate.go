@@ -0,0 +1,197 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// everything wrong with a configuration, reported together so a deploy
+// doesn't have to be retried once per missing variable
+type Problems []string
+
+func (p *Problems) Add(format string, args ...any) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+func (p Problems) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(p, "\n  - ")
+}
+
+type setting struct {
+	name  string
+	value string
+}
+
+// Validate checks the configuration and fills in defaults that depend on other settings
+func (cfg *Config) Validate() Problems {
+	problems := Problems{}
+
+	requireAll(&problems, "", []setting{
+		{"MONGO_DB_URI", cfg.Mongo.URI},
+		{"MONGO_DB_NAME", cfg.Mongo.Database},
+		{"APP_URL", cfg.URLs.App},
+		{"API_URL", cfg.URLs.API},
+		{"JWT_SIGNING_KEY_FILE", cfg.JWT.SigningKeyFile},
+	})
+
+	checkURL(&problems, "APP_URL", cfg.URLs.App)
+	checkURL(&problems, "API_URL", cfg.URLs.API)
+
+	if cfg.JWT.SigningKeyFile != "" {
+		checkFile(&problems, "JWT_SIGNING_KEY_FILE", cfg.JWT.SigningKeyFile)
+	}
+	for _, path := range cfg.JWT.VerificationKeyFiles {
+		checkFile(&problems, "JWT_VERIFICATION_KEY_FILES", path)
+	}
+
+	for _, proxy := range cfg.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				problems.Add("TRUSTED_PROXIES must be IPs or CIDR ranges, got %q", proxy)
+			}
+		}
+	}
+
+	if cfg.Audit.RetentionDays <= 0 {
+		problems.Add("AUDIT_RETENTION_DAYS must be a positive number of days")
+	}
+
+	// integrations are all or nothing, half a setup is a mistake rather than a choice
+	if cfg.Email.Enabled() || cfg.Email.From != "" {
+		requireAll(&problems, "email", []setting{
+			{"RESEND_API_KEY", cfg.Email.APIKey},
+			{"RESEND_FROM", cfg.Email.From},
+		})
+	}
+
+	if cfg.Stripe.SuccessURL == "" {
+		cfg.Stripe.SuccessURL = cfg.URLs.App
+	} else {
+		checkURL(&problems, "SUCCESS_URL", cfg.Stripe.SuccessURL)
+	}
+	if cfg.Stripe.Enabled() || cfg.Stripe.Tokens100ID != "" || cfg.Stripe.Tokens1000ID != "" || cfg.Stripe.Tokens5000ID != "" {
+		requireAll(&problems, "stripe", []setting{
+			{"STRIPE_SECRET_KEY", cfg.Stripe.SecretKey},
+			{"STRIPE_WEBHOOK_SECRET", cfg.Stripe.WebhookSecret},
+			{"TOKENS_100_ID", cfg.Stripe.Tokens100ID},
+			{"TOKENS_1000_ID", cfg.Stripe.Tokens1000ID},
+			{"TOKENS_5000_ID", cfg.Stripe.Tokens5000ID},
+		})
+	}
+
+	if cfg.Storage.Backend == "" && cfg.S3.Configured() {
+		cfg.Storage.Backend = StorageS3
+	}
+	switch cfg.Storage.Backend {
+	case "", StorageLocal:
+	case StorageS3:
+		requireAll(&problems, "S3", []setting{
+			{"S3_REGION", cfg.S3.Region},
+			{"S3_INPUT_BUCKET_NAME", cfg.Storage.InputBucket},
+			{"S3_OUTPUT_BUCKET_NAME", cfg.Storage.OutputBucket},
+		})
+		if (cfg.S3.AccessKeyID == "") != (cfg.S3.SecretAccessKey == "") {
+			problems.Add("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set together")
+		}
+		if cfg.S3.Endpoint != "" {
+			checkURL(&problems, "S3_ENDPOINT", cfg.S3.Endpoint)
+		}
+	default:
+		problems.Add("STORAGE_BACKEND must be %q or %q, got %q", StorageS3, StorageLocal, cfg.Storage.Backend)
+	}
+	for _, bucket := range []setting{
+		{"S3_INPUT_BUCKET_NAME", cfg.Storage.InputBucket},
+		{"S3_OUTPUT_BUCKET_NAME", cfg.Storage.OutputBucket},
+	} {
+		if strings.ContainsAny(bucket.value, `/\`) || bucket.value == "." || bucket.value == ".." {
+			problems.Add("%s must be a plain bucket name, got %q", bucket.name, bucket.value)
+		}
+	}
+
+	if cfg.Inference.Backend == "" && cfg.RunPod.Configured() {
+		cfg.Inference.Backend = InferenceRunPod
+	}
+	switch cfg.Inference.Backend {
+	case "":
+	case InferenceRunPod:
+		requireAll(&problems, "RunPod", []setting{
+			{"RUNPOD_API_KEY", cfg.RunPod.APIKey},
+			{"RUNPOD_ENDPOINT_ID", cfg.RunPod.EndpointID},
+		})
+		checkURL(&problems, "RUNPOD_BASE_URL", cfg.RunPod.BaseURL)
+		// the RunPod workers read their images straight from S3
+		if cfg.Storage.Backend != StorageS3 {
+			problems.Add("the RunPod inference backend needs S3 storage")
+		}
+	case InferenceLocal:
+		if !cfg.Storage.Enabled() {
+			problems.Add("the local inference backend needs storage, set STORAGE_BACKEND")
+		}
+		if cfg.Inference.FakeSeconds < 0 {
+			problems.Add("INFERENCE_FAKE_SECONDS can't be negative")
+		}
+	default:
+		problems.Add("INFERENCE_BACKEND must be %q or %q, got %q", InferenceRunPod, InferenceLocal, cfg.Inference.Backend)
+	}
+	// without a backend nothing is polled
+	if cfg.Inference.Backend != "" {
+		if cfg.Inference.PollSeconds <= 0 {
+			problems.Add("INFERENCE_POLL_SECONDS must be a positive number of seconds")
+		}
+		if cfg.Inference.ReconcileSeconds <= 0 {
+			problems.Add("INFERENCE_RECONCILE_SECONDS must be a positive number of seconds")
+		}
+	}
+
+	return problems
+}
+
+// Disabled names the integrations that are turned off, for a startup warning
+func (cfg *Config) Disabled() []string {
+	disabled := []string{}
+	if !cfg.Email.Enabled() {
+		disabled = append(disabled, "email (messages are logged instead)")
+	}
+	if !cfg.Stripe.Enabled() {
+		disabled = append(disabled, "stripe (purchasing is unavailable)")
+	}
+	if !cfg.Storage.Enabled() {
+		disabled = append(disabled, "storage (experiments and data exports are unavailable)")
+	}
+	if !cfg.Inference.Enabled() {
+		disabled = append(disabled, "inference (experiments can't be submitted)")
+	}
+	return disabled
+}
+
+func requireAll(problems *Problems, integration string, settings []setting) {
+	for _, s := range settings {
+		if s.value != "" {
+			continue
+		}
+		if integration == "" {
+			problems.Add("%s is required", s.name)
+		} else {
+			problems.Add("%s is required when %s is configured", s.name, integration)
+		}
+	}
+}
+
+func checkURL(problems *Problems, name string, value string) {
+	if value == "" {
+		return
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		problems.Add("%s must be an absolute http(s) URL, got %q", name, value)
+	}
+}
+
+func checkFile(problems *Problems, name string, path string) {
+	if _, err := os.Stat(path); err != nil {
+		problems.Add("%s: %v", name, err)
+	}
+}
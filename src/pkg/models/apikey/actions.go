@@ -0,0 +1,149 @@
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/onetime"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func IsAPIKey(value string) bool {
+	return strings.HasPrefix(value, KEY_PREFIX)
+}
+
+func IsValidScope(scope Scope) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// creates a key and returns it along with the raw value, which is never shown again
+func Create(userID primitive.ObjectID, params *CreateAPIKey) (*APIKey, string, error) {
+	secret, err := onetime.GenerateSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	raw := KEY_PREFIX + secret
+
+	scopes := params.Scopes
+	if scopes == nil {
+		scopes = []Scope{}
+	}
+
+	key := APIKey{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Name:      params.Name,
+		Prefix:    raw[:len(KEY_PREFIX)+6],
+		KeyHash:   onetime.HashSecret(raw),
+		Scopes:    scopes,
+		ExpiresAt: params.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(APIKeyCollection)
+	if _, err := collection.InsertOne(context.Background(), key); err != nil {
+		return nil, "", fmt.Errorf("failed to create api key: %v", err)
+	}
+
+	return &key, raw, nil
+}
+
+// lists the keys of a user that haven't been revoked
+func ListForUser(userID primitive.ObjectID) ([]APIKey, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(APIKeyCollection)
+
+	cursor, err := collection.Find(context.Background(),
+		bson.M{"userId": userID, "revokedAt": bson.M{"$exists": false}},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	keys := []APIKey{}
+	if err := cursor.All(context.Background(), &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// revokes a key, returns mongo.ErrNoDocuments if the user has no such key
+func Revoke(userID primitive.ObjectID, keyID primitive.ObjectID) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(APIKeyCollection)
+
+	result, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": keyID, "userId": userID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// looks up a raw key and records where it was used from
+func Authenticate(raw string, ip string) (*APIKey, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(APIKeyCollection)
+
+	now := time.Now()
+	var key APIKey
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{
+			"keyHash":   onetime.HashSecret(raw),
+			"revokedAt": bson.M{"$exists": false},
+			"$or": bson.A{
+				bson.M{"expiresAt": bson.M{"$exists": false}},
+				bson.M{"expiresAt": bson.M{"$gt": now}},
+			},
+		},
+		bson.M{"$set": bson.M{"lastUsedAt": now, "lastUsedIp": ip}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// a key without scopes is unrestricted
+func (key *APIKey) HasScope(scope Scope) bool {
+	if len(key.Scopes) == 0 {
+		return true
+	}
+	for _, s := range key.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// deletes every key of a user, used when their account is deleted
+func DeleteAllForUser(userID primitive.ObjectID) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(APIKeyCollection)
+	_, err := collection.DeleteMany(context.Background(), bson.M{"userId": userID})
+	return err
+}
@@ -0,0 +1,54 @@
+package apikey
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var APIKeyCollection = "api_keys"
+
+// personal API keys for scripted access, only the hash of the key is stored
+// a key without scopes can do everything its owner can, except manage credentials
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     primitive.ObjectID `bson:"userId" json:"userId"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	KeyHash    string             `bson:"keyHash" json:"-"`
+	Scopes     []Scope            `bson:"scopes" json:"scopes"`
+	ExpiresAt  *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	LastUsedIP string             `bson:"lastUsedIp,omitempty" json:"lastUsedIp,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type Scope string
+
+const (
+	ScopeAccountRead      Scope = "account:read"
+	ScopeExperimentsRead  Scope = "experiments:read"
+	ScopeExperimentsWrite Scope = "experiments:write"
+	ScopePurchasing       Scope = "purchasing"
+)
+
+var AllScopes = []Scope{
+	ScopeAccountRead,
+	ScopeExperimentsRead,
+	ScopeExperimentsWrite,
+	ScopePurchasing,
+}
+
+// every key starts with this so the auth middleware can tell it apart from a JWT
+var KEY_PREFIX = "lnk_"
+
+// used for creating a key
+type CreateAPIKey struct {
+	Name      string     `json:"name"`
+	Scopes    []Scope    `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+var ErrInvalidKey = errors.New("api key is invalid, expired or revoked")
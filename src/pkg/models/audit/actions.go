@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// stores the event, failing to audit never fails the action being audited
+func Record(event Event) {
+	event.CreatedAt = time.Now()
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(EventCollection)
+	if _, err := collection.InsertOne(context.Background(), event); err != nil {
+		log.Printf("[Audit] Failed to record %s event: %v", event.Action, err)
+	}
+}
+
+func (filter Filter) query() bson.M {
+	query := bson.M{}
+	if filter.UserID != nil {
+		query["userId"] = *filter.UserID
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+
+	createdAt := bson.M{}
+	if !filter.From.IsZero() {
+		createdAt["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		createdAt["$lt"] = filter.To
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+
+	return query
+}
+
+// newest events first
+func Query(filter Filter, page int, pageSize int) ([]Event, int64, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(EventCollection)
+	query := filter.query()
+
+	total, err := collection.CountDocuments(context.Background(), query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := collection.Find(context.Background(),
+		query,
+		options.Find().
+			SetSkip(int64((page-1)*pageSize)).
+			SetLimit(int64(pageSize)).
+			SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	events := []Event{}
+	if err := cursor.All(context.Background(), &events); err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+// removes the events older than the retention period, the only way events ever go away
+func Purge(retention time.Duration) (int64, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(EventCollection)
+	result, err := collection.DeleteMany(context.Background(), bson.M{
+		"createdAt": bson.M{"$lt": time.Now().Add(-retention)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var EventCollection = "audit_events"
+
+// one security or billing relevant thing that happened, events are only ever
+// inserted and removed again once they are older than the retention period.
+// UserID is whose account it concerns, ActorID who did it when that's someone else
+// e.g. an admin, both are empty for system events like a failed login of an unknown email
+type Event struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	Action         Action              `bson:"action" json:"action"`
+	UserID         *primitive.ObjectID `bson:"userId,omitempty" json:"userId,omitempty"`
+	ActorID        *primitive.ObjectID `bson:"actorId,omitempty" json:"actorId,omitempty"`
+	OrganizationID *primitive.ObjectID `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
+	IP             string              `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent      string              `bson:"userAgent,omitempty" json:"userAgent,omitempty"`
+	Details        map[string]any      `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt      time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+type Action string
+
+const (
+	ActionRegistered           Action = "REGISTERED"
+	ActionEmailVerified        Action = "EMAIL_VERIFIED"
+	ActionLoginSucceeded       Action = "LOGIN_SUCCEEDED"
+	ActionLoginFailed          Action = "LOGIN_FAILED"
+	ActionAccountLocked        Action = "ACCOUNT_LOCKED"
+	ActionAccountUnlocked      Action = "ACCOUNT_UNLOCKED"
+	ActionLoggedOut            Action = "LOGGED_OUT"
+	ActionSessionRevoked       Action = "SESSION_REVOKED"
+	ActionPasswordChanged      Action = "PASSWORD_CHANGED"
+	ActionPasswordReset        Action = "PASSWORD_RESET"
+	ActionEmailChangeRequested Action = "EMAIL_CHANGE_REQUESTED"
+	ActionEmailChanged         Action = "EMAIL_CHANGED"
+	ActionTwoFactorEnabled     Action = "TWO_FACTOR_ENABLED"
+	ActionTwoFactorDisabled    Action = "TWO_FACTOR_DISABLED"
+	ActionAPIKeyCreated        Action = "API_KEY_CREATED"
+	ActionAPIKeyRevoked        Action = "API_KEY_REVOKED"
+	ActionRoleChanged          Action = "ROLE_CHANGED"
+	ActionTokensAdjusted       Action = "TOKENS_ADJUSTED"
+	ActionModelTypeChanged     Action = "MODEL_TYPE_CHANGED"
+	ActionAccountDisabled      Action = "ACCOUNT_DISABLED"
+	ActionAccountEnabled       Action = "ACCOUNT_ENABLED"
+	ActionForcedLogout         Action = "FORCED_LOGOUT"
+	ActionImpersonationStarted Action = "IMPERSONATION_STARTED"
+	ActionSSOProviderCreated   Action = "SSO_PROVIDER_CREATED"
+	ActionSSOProviderUpdated   Action = "SSO_PROVIDER_UPDATED"
+	ActionSSOProviderDisabled  Action = "SSO_PROVIDER_DISABLED"
+	ActionSSOProviderEnabled   Action = "SSO_PROVIDER_ENABLED"
+	ActionDataExportRequested  Action = "DATA_EXPORT_REQUESTED"
+	ActionDeletionRequested    Action = "ACCOUNT_DELETION_REQUESTED"
+	ActionTokensPurchased      Action = "TOKENS_PURCHASED"
+	ActionTokensDeducted       Action = "TOKENS_DEDUCTED"
+	ActionExperimentCreated    Action = "EXPERIMENT_CREATED"
+	ActionExperimentDownloaded Action = "EXPERIMENT_DOWNLOADED"
+	ActionExperimentFailed     Action = "EXPERIMENT_FAILED"
+	ActionExperimentCancelled  Action = "EXPERIMENT_CANCELLED"
+	ActionExperimentRerun      Action = "EXPERIMENT_RERUN"
+)
+
+// narrows a query, zero values match everything
+type Filter struct {
+	UserID *primitive.ObjectID
+	Action Action
+	From   time.Time
+	To     time.Time
+}
@@ -5,27 +5,27 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"time"
 
 	"api.lnlink.net/src/pkg/global"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"api.lnlink.net/src/pkg/services/inference"
+	"api.lnlink.net/src/pkg/services/storage"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// the id can be set beforehand, e.g. to point callbacks at the group before it is stored
 func (exp *MultiExperiment) Create(userID primitive.ObjectID, name string) error {
-	exp.ID = primitive.NewObjectID()
+	if exp.ID.IsZero() {
+		exp.ID = primitive.NewObjectID()
+	}
 	exp.UserID = userID
 	exp.Name = name
 
 	exp.CreatedAt = time.Now()
 
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(MultiExperimentCollection)
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(MultiExperimentCollection)
 	_, err := collection.InsertOne(context.Background(), exp)
 	if err != nil {
 		return err
@@ -39,12 +39,145 @@ func (exp *MultiExperiment) AddExperiment(experiment Experiment) error {
 	return nil
 }
 
-// GetExperiments retrieves paginated experiments for a user
-func GetExperiments(userID primitive.ObjectID, page, pageSize int) ([]MultiExperiment, int64, error) {
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(MultiExperimentCollection)
+// VisibilityFilter matches the experiments a user submitted and the ones of their organization
+func VisibilityFilter(userID primitive.ObjectID, organizationID *primitive.ObjectID) bson.M {
+	if organizationID == nil {
+		return bson.M{"userId": userID}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"userId": userID},
+		bson.M{"organizationId": *organizationID},
+	}}
+}
+
+// GetExperimentByID finds an experiment group, callers check permissions
+func GetExperimentByID(experimentID primitive.ObjectID) (*MultiExperiment, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(MultiExperimentCollection)
+
+	var experiment MultiExperiment
+	err := collection.FindOne(context.Background(), bson.M{"_id": experimentID}).Decode(&experiment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &experiment, nil
+}
+
+// ListSubmittedBy returns every experiment group a user submitted, oldest first
+func ListSubmittedBy(userID primitive.ObjectID) ([]MultiExperiment, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(MultiExperimentCollection)
+
+	cursor, err := collection.Find(context.Background(),
+		bson.M{"userId": userID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	experiments := []MultiExperiment{}
+	if err = cursor.All(context.Background(), &experiments); err != nil {
+		return nil, err
+	}
+
+	return experiments, nil
+}
+
+// Delete removes the experiment group record, its files are the caller's job
+func (exp *MultiExperiment) Delete() error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(MultiExperimentCollection)
+	_, err := collection.DeleteOne(context.Background(), bson.M{"_id": exp.ID})
+	return err
+}
+
+// ListGroups returns the newest experiment groups, optionally only the ones of a user
+// or with at least one image in the given status
+func ListGroups(userID *primitive.ObjectID, status ExperimentStatus, limit int) ([]MultiExperiment, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(MultiExperimentCollection)
+
+	filter := bson.M{}
+	if userID != nil {
+		filter["userId"] = *userID
+	}
+	if status != "" {
+		filter["experiments"] = bson.M{"$elemMatch": bson.M{"status": status}}
+	}
+
+	cursor, err := collection.Find(context.Background(),
+		filter,
+		options.Find().
+			SetLimit(int64(limit)).
+			SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	experiments := []MultiExperiment{}
+	if err = cursor.All(context.Background(), &experiments); err != nil {
+		return nil, err
+	}
+
+	return experiments, nil
+}
+
+// Transition replaces one image only if it is still the way it was read, so
+// callbacks and the cron can race without applying a result twice.
+// false means someone else moved the image first
+func (exp *MultiExperiment) Transition(from Experiment, to Experiment) (bool, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(MultiExperimentCollection)
+	result, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{
+			"_id": exp.ID,
+			"experiments": bson.M{"$elemMatch": bson.M{
+				"fileId":   from.FileID,
+				"status":   from.Status,
+				"runpodID": from.JobID,
+			}},
+		},
+		bson.M{"$set": bson.M{"experiments.$": to}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+	for i := range exp.Experiments {
+		if exp.Experiments[i].FileID == to.FileID {
+			exp.Experiments[i] = to
+		}
+	}
+	return true, nil
+}
+
+// SetDownloadURL stores a freshly generated download link
+func (exp *MultiExperiment) SetDownloadURL(downloadURL string) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(MultiExperimentCollection)
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": exp.ID},
+		bson.M{"$set": bson.M{"downloadUrl": downloadURL}},
+	)
+	if err != nil {
+		return err
+	}
+
+	exp.DownloadURL = downloadURL
+	return nil
+}
+
+// GetExperiments retrieves paginated experiments visible to a user
+func GetExperiments(userID primitive.ObjectID, organizationID *primitive.ObjectID, page, pageSize int) ([]MultiExperiment, int64, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(MultiExperimentCollection)
+	filter := VisibilityFilter(userID, organizationID)
 
 	// Get total count
-	total, err := collection.CountDocuments(context.Background(), bson.M{"userId": userID})
+	total, err := collection.CountDocuments(context.Background(), filter)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -52,7 +185,7 @@ func GetExperiments(userID primitive.ObjectID, page, pageSize int) ([]MultiExper
 	// Get paginated results
 	skip := int64((page - 1) * pageSize)
 	cursor, err := collection.Find(context.Background(),
-		bson.M{"userId": userID},
+		filter,
 		options.Find().
 			SetSkip(skip).
 			SetLimit(int64(pageSize)).
@@ -71,9 +204,29 @@ func GetExperiments(userID primitive.ObjectID, page, pageSize int) ([]MultiExper
 	return experiments, total, nil
 }
 
+// Submitted returns the image running as a freshly submitted job
+func (exp Experiment) Submitted(jobID string) Experiment {
+	now := time.Now()
+	exp.Status = ExperimentInProgress
+	exp.JobID = jobID
+	exp.SubmittedAt = &now
+	exp.StartedAt = nil
+	exp.FailureReason = ""
+	return exp
+}
+
+// what the inference backend needs to run the image
+func (exp Experiment) InferenceJob() inference.Job {
+	return inference.Job{
+		FileID:          exp.FileID,
+		FileExtension:   exp.FileExtension,
+		MicronsPerPixel: exp.MicronsPerPixel,
+	}
+}
+
 // GenerateDownloadLink creates a presigned URL for downloading experiment results
 func GenerateDownloadLink(experimentID primitive.ObjectID) (string, error) {
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(MultiExperimentCollection)
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(MultiExperimentCollection)
 
 	var experiment MultiExperiment
 	err := collection.FindOne(context.Background(), bson.M{"_id": experimentID}).Decode(&experiment)
@@ -81,20 +234,14 @@ func GenerateDownloadLink(experimentID primitive.ObjectID) (string, error) {
 		return "", err
 	}
 
-	// Configure AWS S3 client
-	cfg, err := config.LoadDefaultConfig(context.Background(),
-		config.WithRegion(global.S3_REGION),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			global.S3_ACCESS_KEY_ID,
-			global.S3_SECRET_ACCESS_KEY,
-			"",
-		)),
-	)
+	store, err := storage.Active()
 	if err != nil {
 		return "", err
 	}
-
-	s3Client := s3.NewFromConfig(cfg)
+	if store == nil {
+		return "", fmt.Errorf("storage is not configured")
+	}
+	outputBucket := global.CONFIG.Storage.OutputBucket
 
 	// Create a zip file containing all experiment results
 	zipKey := fmt.Sprintf("downloads/%s.zip", experimentID.Hex())
@@ -105,10 +252,15 @@ func GenerateDownloadLink(experimentID primitive.ObjectID) (string, error) {
 
 	// Add each experiment's output files to the zip
 	for _, exp := range experiment.Experiments {
+		// cancelled images may have left partial outputs behind
+		if exp.Status != ExperimentCompleted {
+			continue
+		}
+
 		// Add mask files (_0 and _1)
 		for i := 0; i <= 1; i++ {
 			maskKey := fmt.Sprintf("innocent/%s_%d.png", exp.FileID, i)
-			maskData, err := downloadFromS3(s3Client, global.S3_OUTPUT_BUCKET_NAME, maskKey)
+			maskData, err := store.Get(context.Background(), outputBucket, maskKey)
 			if err == nil {
 				if err := addFileToZip(zipWriter, fmt.Sprintf("%s_mask_%d.png", exp.FileID, i), maskData); err != nil {
 					return "", fmt.Errorf("failed to add mask file to zip: %v", err)
@@ -118,7 +270,7 @@ func GenerateDownloadLink(experimentID primitive.ObjectID) (string, error) {
 
 		// Add results file
 		resultsKey := fmt.Sprintf("innocent/%s.json", exp.FileID)
-		resultsData, err := downloadFromS3(s3Client, global.S3_OUTPUT_BUCKET_NAME, resultsKey)
+		resultsData, err := store.Get(context.Background(), outputBucket, resultsKey)
 		if err == nil {
 			if err := addFileToZip(zipWriter, fmt.Sprintf("%s_results.json", exp.FileID), resultsData); err != nil {
 				return "", fmt.Errorf("failed to add results file to zip: %v", err)
@@ -128,7 +280,7 @@ func GenerateDownloadLink(experimentID primitive.ObjectID) (string, error) {
 		// Add table files (_0 and _1)
 		for i := 0; i <= 1; i++ {
 			tableKey := fmt.Sprintf("innocent/%s_%d.xlsx", exp.FileID, i)
-			tableData, err := downloadFromS3(s3Client, global.S3_OUTPUT_BUCKET_NAME, tableKey)
+			tableData, err := store.Get(context.Background(), outputBucket, tableKey)
 			if err == nil {
 				if err := addFileToZip(zipWriter, fmt.Sprintf("%s_table_%d.xlsx", exp.FileID, i), tableData); err != nil {
 					return "", fmt.Errorf("failed to add table file to zip: %v", err)
@@ -142,48 +294,14 @@ func GenerateDownloadLink(experimentID primitive.ObjectID) (string, error) {
 		return "", fmt.Errorf("failed to close zip writer: %v", err)
 	}
 
-	// Upload the zip file to S3
-	_, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{
-		Bucket:      aws.String(global.S3_OUTPUT_BUCKET_NAME),
-		Key:         aws.String(zipKey),
-		Body:        bytes.NewReader(buf.Bytes()),
-		ContentType: aws.String("application/zip"),
-	})
+	// Upload the zip file
+	err = store.Put(context.Background(), outputBucket, zipKey, bytes.NewReader(buf.Bytes()), "application/zip")
 	if err != nil {
 		return "", fmt.Errorf("failed to upload zip file: %v", err)
 	}
 
-	// Generate presigned URL for the zip file
-	presignClient := s3.NewPresignClient(s3Client)
-	presignResult, err := presignClient.PresignGetObject(context.Background(), &s3.GetObjectInput{
-		Bucket: aws.String(global.S3_OUTPUT_BUCKET_NAME),
-		Key:    aws.String(zipKey),
-	}, func(opts *s3.PresignOptions) {
-		opts.Expires = time.Hour * 24 // URL valid for 24 hours
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to generate presigned URL: %v", err)
-	}
-
-	return presignResult.URL, nil
-}
-
-// Helper function to download a file from S3
-func downloadFromS3(s3Client *s3.Client, bucket, key string) ([]byte, error) {
-	result, err := s3Client.GetObject(context.Background(), &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to download from S3: %v", err)
-	}
-	defer result.Body.Close()
-
-	data, err := io.ReadAll(result.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read S3 object: %v", err)
-	}
-	return data, nil
+	// Generate presigned URL for the zip file, valid for 24 hours
+	return store.Presign(context.Background(), outputBucket, zipKey, time.Hour*24)
 }
 
 // Helper function to add a file to a zip archive
@@ -15,16 +15,50 @@ type MultiExperiment struct {
 	Experiments []Experiment       `bson:"experiments,omitempty" json:"experiments,omitempty"`
 	CreatedAt   time.Time          `bson:"createdAt,omitempty" json:"createdAt,omitempty"`
 	DownloadURL string             `bson:"downloadUrl,omitempty" json:"downloadUrl,omitempty"`
+	// the model the images were submitted to, empty for groups from before it was recorded
+	ModelType string `bson:"modelType,omitempty" json:"modelType,omitempty"`
+
+	// set when the submitter was in an organization, its members can see the experiment
+	// and its wallet pays for it
+	OrganizationID *primitive.ObjectID `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
 }
 
 type Experiment struct {
-	FileID              string           `bson:"fileId,omitempty" json:"fileId,omitempty"`
-	FileExtension       string           `bson:"fileExtension,omitempty" json:"fileExtension,omitempty"`
-	RunpodID            string           `bson:"runpodID,omitempty" json:"runpodID,omitempty"`
+	FileID        string `bson:"fileId,omitempty" json:"fileId,omitempty"`
+	FileExtension string `bson:"fileExtension,omitempty" json:"fileExtension,omitempty"`
+	// the job at the inference backend, stored under the name it had when RunPod was the only one
+	JobID               string           `bson:"runpodID,omitempty" json:"runpodID,omitempty"`
 	ExecutionTimeMillis int              `bson:"executionTimeMillis,omitempty" json:"executionTimeMillis,omitempty"`
 	Status              ExperimentStatus `bson:"status,omitempty" json:"status,omitempty"`
 	RetryCount          int              `bson:"retryCount,omitempty" json:"retryCount,omitempty"`
 	MicronsPerPixel     float64          `bson:"micronsPerPixel,omitempty" json:"micronsPerPixel,omitempty"`
+	// when the current job was submitted and when the backend started running it
+	SubmittedAt *time.Time `bson:"submittedAt,omitempty" json:"submittedAt,omitempty"`
+	StartedAt   *time.Time `bson:"startedAt,omitempty" json:"startedAt,omitempty"`
+	// why the image failed, e.g. a timeout or the backend's error
+	FailureReason string `bson:"failureReason,omitempty" json:"failureReason,omitempty"`
+	// earlier runs of the image, oldest first
+	Reruns []Rerun `bson:"reruns,omitempty" json:"reruns,omitempty"`
+}
+
+// Rerun records a run that was replaced by resubmitting the image
+type Rerun struct {
+	RequestedAt time.Time          `bson:"requestedAt" json:"requestedAt"`
+	RequestedBy primitive.ObjectID `bson:"requestedBy" json:"requestedBy"`
+	// how the replaced run went
+	JobID           string           `bson:"runpodID,omitempty" json:"runpodID,omitempty"`
+	Status          ExperimentStatus `bson:"status" json:"status"`
+	FailureReason   string           `bson:"failureReason,omitempty" json:"failureReason,omitempty"`
+	MicronsPerPixel float64          `bson:"micronsPerPixel,omitempty" json:"micronsPerPixel,omitempty"`
+	// key prefix its outputs were moved to, empty when it left none
+	OutputPrefix string `bson:"outputPrefix,omitempty" json:"outputPrefix,omitempty"`
+}
+
+// RerunRequest resubmits images, parameters that are left out keep their value
+type RerunRequest struct {
+	// the images to rerun, all failed ones when empty. ignored for single images
+	FileIDs         []string `json:"fileIds"`
+	MicronsPerPixel *float64 `json:"micronsPerPixel"`
 }
 
 type ExperimentStatus string
@@ -34,4 +68,6 @@ const (
 	ExperimentInProgress ExperimentStatus = "IN_PROGRESS"
 	ExperimentCompleted  ExperimentStatus = "COMPLETED"
 	ExperimentFailed     ExperimentStatus = "FAILED"
+	// stopped by the user, cancelled images are never charged
+	ExperimentCancelled ExperimentStatus = "CANCELLED"
 )
@@ -0,0 +1,16 @@
+package indexes
+
+import (
+	"api.lnlink.net/src/pkg/errs"
+	"api.lnlink.net/src/pkg/models/sso"
+	"api.lnlink.net/src/pkg/models/user"
+)
+
+// Ensure creates the indexes the models rely on, call after global.Init.
+// creating an index that exists is a no-op, so every entrypoint calls it
+func Ensure() {
+	for _, ensure := range []func() error{user.EnsureIndexes, sso.EnsureIndexes} {
+		err := ensure()
+		errs.Invariant(err == nil, "%v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package invitation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/onetime"
+	"api.lnlink.net/src/pkg/models/user"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func collection() *mongo.Collection {
+	return global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(InvitationCollection)
+}
+
+// matches invitations that can still be accepted
+func pendingFilter() bson.M {
+	return bson.M{
+		"acceptedAt": bson.M{"$exists": false},
+		"revokedAt":  bson.M{"$exists": false},
+		"expiresAt":  bson.M{"$gt": time.Now()},
+	}
+}
+
+// creates an invitation and returns the secret to email, the secret is never stored
+func Create(organizationID primitive.ObjectID, email string, role user.OrganizationRole, invitedBy primitive.ObjectID) (*Invitation, string, error) {
+	email = user.NormalizeEmail(email)
+
+	filter := pendingFilter()
+	filter["organizationId"] = organizationID
+	filter["email"] = email
+	count, err := collection().CountDocuments(context.Background(), filter)
+	if err != nil {
+		return nil, "", fmt.Errorf("can't check invitations: %v", err)
+	}
+	if count > 0 {
+		return nil, "", ErrAlreadyInvited
+	}
+
+	secret, err := onetime.GenerateSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	invitation := Invitation{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: organizationID,
+		Email:          email,
+		Role:           role,
+		InvitedBy:      invitedBy,
+		TokenHash:      onetime.HashSecret(secret),
+		ExpiresAt:      now.Add(DEFAULT_EXPIRATION_TIME),
+		LastSentAt:     now,
+		CreatedAt:      now,
+	}
+
+	if _, err := collection().InsertOne(context.Background(), invitation); err != nil {
+		return nil, "", fmt.Errorf("can't create invitation: %v", err)
+	}
+
+	return &invitation, secret, nil
+}
+
+// pending invitations of an organization, newest first
+func ListPending(organizationID primitive.ObjectID) ([]Invitation, error) {
+	filter := pendingFilter()
+	filter["organizationId"] = organizationID
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := collection().Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("can't list invitations: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	invitations := []Invitation{}
+	if err := cursor.All(context.Background(), &invitations); err != nil {
+		return nil, fmt.Errorf("can't decode invitations: %v", err)
+	}
+
+	return invitations, nil
+}
+
+// issues a new secret and restarts the expiry, the previous link stops working
+func Resend(invitationID primitive.ObjectID, organizationID primitive.ObjectID) (*Invitation, string, error) {
+	secret, err := onetime.GenerateSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// an expired invitation can be resent, an accepted or revoked one can't
+	filter := bson.M{
+		"_id":            invitationID,
+		"organizationId": organizationID,
+		"acceptedAt":     bson.M{"$exists": false},
+		"revokedAt":      bson.M{"$exists": false},
+	}
+	now := time.Now()
+	update := bson.M{"$set": bson.M{
+		"tokenHash":  onetime.HashSecret(secret),
+		"expiresAt":  now.Add(DEFAULT_EXPIRATION_TIME),
+		"lastSentAt": now,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var invitation Invitation
+	err = collection().FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&invitation)
+	if err == mongo.ErrNoDocuments {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("can't resend invitation: %v", err)
+	}
+
+	return &invitation, secret, nil
+}
+
+func Revoke(invitationID primitive.ObjectID, organizationID primitive.ObjectID) error {
+	result, err := collection().UpdateOne(
+		context.Background(),
+		bson.M{
+			"_id":            invitationID,
+			"organizationId": organizationID,
+			"acceptedAt":     bson.M{"$exists": false},
+			"revokedAt":      bson.M{"$exists": false},
+		},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("can't revoke invitation: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// finds a pending invitation without using it up
+func Lookup(secret string) (*Invitation, error) {
+	filter := pendingFilter()
+	filter["tokenHash"] = onetime.HashSecret(secret)
+
+	var invitation Invitation
+	err := collection().FindOne(context.Background(), filter).Decode(&invitation)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &invitation, nil
+}
+
+// marks the invitation accepted, only one caller can win if it's used twice at once
+func (invitation *Invitation) MarkAccepted(userID primitive.ObjectID) error {
+	filter := pendingFilter()
+	filter["_id"] = invitation.ID
+	filter["tokenHash"] = invitation.TokenHash
+
+	now := time.Now()
+	result, err := collection().UpdateOne(
+		context.Background(),
+		filter,
+		bson.M{"$set": bson.M{"acceptedAt": now, "acceptedBy": userID}},
+	)
+	if err != nil {
+		return fmt.Errorf("can't accept invitation: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidToken
+	}
+
+	invitation.AcceptedAt = &now
+	invitation.AcceptedBy = &userID
+	return nil
+}
+
+// undoes MarkAccepted when the account it was accepted for couldn't be created
+func (invitation *Invitation) Reopen(userID primitive.ObjectID) error {
+	_, err := collection().UpdateOne(
+		context.Background(),
+		bson.M{"_id": invitation.ID, "acceptedBy": userID},
+		bson.M{"$unset": bson.M{"acceptedAt": "", "acceptedBy": ""}},
+	)
+	if err != nil {
+		return fmt.Errorf("can't reopen invitation: %v", err)
+	}
+
+	invitation.AcceptedAt = nil
+	invitation.AcceptedBy = nil
+	return nil
+}
+
+// checks that the invitation was sent to the user's address
+func (invitation *Invitation) IsFor(u *user.User) bool {
+	return strings.EqualFold(invitation.Email, u.Email)
+}
+
+// deletes the invitations sent to an address, used when its account is deleted.
+// matches case insensitively, invitations from before emails were normalized can differ
+func DeleteForEmail(email string) error {
+	caseInsensitive := options.Delete().SetCollation(&options.Collation{Locale: "en", Strength: 2})
+	_, err := collection().DeleteMany(context.Background(), bson.M{"email": user.NormalizeEmail(email)}, caseInsensitive)
+	if err != nil {
+		return fmt.Errorf("can't delete invitations: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package invitation
+
+import (
+	"errors"
+	"time"
+
+	"api.lnlink.net/src/pkg/models/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var InvitationCollection = "invitations"
+
+var DEFAULT_EXPIRATION_TIME = 7 * 24 * time.Hour
+
+// an invitation to join an organization, only the hash of the emailed token is stored
+type Invitation struct {
+	ID             primitive.ObjectID    `bson:"_id,omitempty" json:"id,omitempty"`
+	OrganizationID primitive.ObjectID    `bson:"organizationId" json:"organizationId"`
+	Email          string                `bson:"email" json:"email"`
+	Role           user.OrganizationRole `bson:"role" json:"role"`
+	InvitedBy      primitive.ObjectID    `bson:"invitedBy" json:"invitedBy"`
+	TokenHash      string                `bson:"tokenHash" json:"-"`
+	ExpiresAt      time.Time             `bson:"expiresAt" json:"expiresAt"`
+	LastSentAt     time.Time             `bson:"lastSentAt" json:"lastSentAt"`
+	AcceptedAt     *time.Time            `bson:"acceptedAt,omitempty" json:"acceptedAt,omitempty"`
+	AcceptedBy     *primitive.ObjectID   `bson:"acceptedBy,omitempty" json:"acceptedBy,omitempty"`
+	RevokedAt      *time.Time            `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	CreatedAt      time.Time             `bson:"createdAt" json:"createdAt"`
+}
+
+// used for inviting someone to an organization
+type CreateInvitation struct {
+	Email string                `json:"email"`
+	Role  user.OrganizationRole `json:"role"`
+}
+
+// used for looking up and accepting an invitation as a signed in user
+type InvitationToken struct {
+	Token string `json:"token"`
+}
+
+// used for accepting an invitation by creating a new account
+type AcceptInvitationRegister struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+var ErrInvalidToken = errors.New("invalid or expired invitation")
+var ErrAlreadyInvited = errors.New("email already has a pending invitation")
+var ErrNotFound = errors.New("invitation not found")
@@ -0,0 +1,193 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/onetime"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func collection() *mongo.Collection {
+	return global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(JobCollection)
+}
+
+// queues a job and returns the secret for following it, only its hash is stored
+func Create(userID primitive.ObjectID, kind Kind) (*Job, string, error) {
+	count, err := collection().CountDocuments(context.Background(), bson.M{
+		"userId": userID,
+		"kind":   kind,
+		"status": bson.M{"$in": bson.A{JobPending, JobRunning}},
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("can't check jobs: %v", err)
+	}
+	if count > 0 {
+		return nil, "", ErrAlreadyQueued
+	}
+
+	secret, err := onetime.GenerateSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	job := Job{
+		ID:              primitive.NewObjectID(),
+		UserID:          userID,
+		Kind:            kind,
+		Status:          JobPending,
+		StatusTokenHash: onetime.HashSecret(secret),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	if _, err := collection().InsertOne(context.Background(), job); err != nil {
+		return nil, "", fmt.Errorf("can't create job: %v", err)
+	}
+
+	return &job, secret, nil
+}
+
+func GetForUser(jobID primitive.ObjectID, userID primitive.ObjectID) *Job {
+	var job Job
+	err := collection().FindOne(context.Background(), bson.M{"_id": jobID, "userId": userID}).Decode(&job)
+	if err != nil {
+		return nil
+	}
+	return &job
+}
+
+func GetByStatusToken(secret string) *Job {
+	var job Job
+	err := collection().FindOne(context.Background(), bson.M{"statusTokenHash": onetime.HashSecret(secret)}).Decode(&job)
+	if err != nil {
+		return nil
+	}
+	return &job
+}
+
+func ListForUser(userID primitive.ObjectID) ([]Job, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := collection().Find(context.Background(), bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("can't list jobs: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	jobs := []Job{}
+	if err := cursor.All(context.Background(), &jobs); err != nil {
+		return nil, fmt.Errorf("can't decode jobs: %v", err)
+	}
+
+	return jobs, nil
+}
+
+// atomically takes the oldest pending, stale or retryable job, nil when there is none
+func ClaimNext() (*Job, error) {
+	now := time.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": JobPending},
+		bson.M{"status": JobRunning, "updatedAt": bson.M{"$lt": now.Add(-STALE_AFTER)}},
+		bson.M{"status": JobFailed, "kind": KindAccountDeletion, "updatedAt": bson.M{"$lt": now.Add(-RETRY_AFTER)}},
+	}}
+	update := bson.M{
+		"$set": bson.M{
+			"status":    JobRunning,
+			"startedAt": now,
+			"updatedAt": now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job Job
+	err := collection().FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't claim job: %v", err)
+	}
+
+	return &job, nil
+}
+
+// completed exports that still have a download link and finished before the given time
+func ListExportsFinishedBefore(before time.Time) ([]Job, error) {
+	cursor, err := collection().Find(context.Background(), bson.M{
+		"kind":        KindDataExport,
+		"status":      JobCompleted,
+		"downloadUrl": bson.M{"$exists": true, "$ne": ""},
+		"finishedAt":  bson.M{"$lt": before},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't list exports: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	jobs := []Job{}
+	if err := cursor.All(context.Background(), &jobs); err != nil {
+		return nil, fmt.Errorf("can't decode jobs: %v", err)
+	}
+
+	return jobs, nil
+}
+
+// drops the link once the archive behind it is gone
+func (job *Job) ClearDownload() error {
+	job.DownloadURL = ""
+	return job.update(bson.M{"downloadUrl": ""})
+}
+
+func (job *Job) update(set bson.M) error {
+	set["updatedAt"] = time.Now()
+	_, err := collection().UpdateOne(context.Background(), bson.M{"_id": job.ID}, bson.M{"$set": set})
+	if err != nil {
+		return fmt.Errorf("can't update job: %v", err)
+	}
+	return nil
+}
+
+// progress is a percentage, step a short description of what's being done
+func (job *Job) ReportProgress(progress int, step string) error {
+	job.Progress = progress
+	job.Step = step
+	return job.update(bson.M{"progress": progress, "step": step})
+}
+
+func (job *Job) Complete(downloadURL string) error {
+	now := time.Now()
+	job.Status = JobCompleted
+	job.Progress = 100
+	job.DownloadURL = downloadURL
+	job.Error = ""
+	job.FinishedAt = &now
+	return job.update(bson.M{
+		"status":      JobCompleted,
+		"progress":    100,
+		"step":        "",
+		"error":       "",
+		"downloadUrl": downloadURL,
+		"finishedAt":  now,
+	})
+}
+
+func (job *Job) Fail(cause error) error {
+	now := time.Now()
+	job.Status = JobFailed
+	job.Error = cause.Error()
+	job.FinishedAt = &now
+	return job.update(bson.M{
+		"status":     JobFailed,
+		"error":      cause.Error(),
+		"finishedAt": now,
+	})
+}
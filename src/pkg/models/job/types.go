@@ -0,0 +1,60 @@
+package job
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var JobCollection = "jobs"
+
+// a running job that hasn't reported progress for this long is assumed
+// to have died with its server and gets picked up again
+var STALE_AFTER = 10 * time.Minute
+
+// failed account deletions are tried again after this long, every step of a
+// deletion can be repeated and it has to finish eventually
+var RETRY_AFTER = 15 * time.Minute
+
+// long running work on behalf of a user, picked up by the job cron
+// StatusTokenHash lets the client follow an account deletion after its sessions are gone
+type Job struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID          primitive.ObjectID `bson:"userId" json:"userId"`
+	Kind            Kind               `bson:"kind" json:"kind"`
+	Status          Status             `bson:"status" json:"status"`
+	Progress        int                `bson:"progress" json:"progress"`
+	Step            string             `bson:"step,omitempty" json:"step,omitempty"`
+	Error           string             `bson:"error,omitempty" json:"error,omitempty"`
+	Attempts        int                `bson:"attempts" json:"attempts"`
+	DownloadURL     string             `bson:"downloadUrl,omitempty" json:"downloadUrl,omitempty"`
+	StatusTokenHash string             `bson:"statusTokenHash" json:"-"`
+	CreatedAt       time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt       time.Time          `bson:"updatedAt" json:"updatedAt"`
+	StartedAt       *time.Time         `bson:"startedAt,omitempty" json:"startedAt,omitempty"`
+	FinishedAt      *time.Time         `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+}
+
+type Kind string
+
+const (
+	KindDataExport      Kind = "DATA_EXPORT"
+	KindAccountDeletion Kind = "ACCOUNT_DELETION"
+)
+
+type Status string
+
+const (
+	JobPending   Status = "PENDING"
+	JobRunning   Status = "RUNNING"
+	JobCompleted Status = "COMPLETED"
+	JobFailed    Status = "FAILED"
+)
+
+// used for following a job without being signed in
+type JobStatusToken struct {
+	Token string `json:"token"`
+}
+
+var ErrAlreadyQueued = errors.New("a job of this kind is already queued")
@@ -1,9 +1,9 @@
 package jwt
 
 import (
+	"fmt"
 	"time"
 
-	"api.lnlink.net/src/pkg/global"
 	jwtv5 "github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -11,8 +11,17 @@ import (
 
 // creates a JWT for a user ID
 func CreateJWT(userID primitive.ObjectID) (Token, error) {
+	return createJWT(userID, DEFAULT_EXPIRATION_TIME, "")
+}
+
+// creates a JWT that lets an admin act as the user, the admin is named in the token
+func CreateImpersonationJWT(userID primitive.ObjectID, adminID primitive.ObjectID) (Token, error) {
+	return createJWT(userID, IMPERSONATION_EXPIRATION_TIME, adminID.Hex())
+}
+
+func createJWT(userID primitive.ObjectID, ttl time.Duration, actor string) (Token, error) {
 	now := time.Now()
-	expiresAt := now.Add(DEFAULT_EXPIRATION_TIME)
+	expiresAt := now.Add(ttl)
 	id := uuid.New().String()
 
 	claims := Claims{
@@ -23,10 +32,13 @@ func CreateJWT(userID primitive.ObjectID) (Token, error) {
 		IssuedAt:  now.Unix(),
 		NotBefore: now.Unix(),
 		JWTID:     id,
+		Actor:     actor,
 	}
 
-	token := jwtv5.NewWithClaims(jwtv5.SigningMethodHS256, claims.ToRawClaims())
-	tokenString, err := token.SignedString([]byte(global.JWT_SIGNING_KEY))
+	key := verificationKeys[signingKeyID]
+	token := jwtv5.NewWithClaims(key.method, claims.ToRawClaims())
+	token.Header["kid"] = signingKeyID
+	tokenString, err := token.SignedString(signingKey)
 	if err != nil {
 		return Token{}, err
 	}
@@ -40,8 +52,16 @@ func CreateJWT(userID primitive.ObjectID) (Token, error) {
 // validate's a token string and returns the token and the claims
 func ValidateJWT(token string) (bool, *Token) {
 	parsedToken, err := jwtv5.Parse(token, func(token *jwtv5.Token) (interface{}, error) {
-		return []byte(global.JWT_SIGNING_KEY), nil
-	}, jwtv5.WithValidMethods([]string{jwtv5.SigningMethodHS256.Name}))
+		kid, _ := token.Header["kid"].(string)
+		key, ok := verificationKeys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %s", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("key %s doesn't sign with %s", kid, token.Method.Alg())
+		}
+		return key.public, nil
+	}, jwtv5.WithValidMethods([]string{jwtv5.SigningMethodEdDSA.Alg(), jwtv5.SigningMethodRS256.Alg()}))
 
 	if err != nil || !parsedToken.Valid {
 		return false, nil
@@ -62,6 +82,10 @@ func ValidateJWT(token string) (bool, *Token) {
 		JWTID:     claims["jti"].(string),
 	}
 
+	if act, ok := claims["act"].(map[string]interface{}); ok {
+		parsedClaims.Actor, _ = act["sub"].(string)
+	}
+
 	if parsedClaims.ExpiresAt < time.Now().Unix() {
 		return false, nil
 	}
@@ -84,5 +108,9 @@ func (jwt *Claims) ToRawClaims() jwtv5.Claims {
 		"jti": jwt.JWTID,
 	}
 
+	if jwt.Actor != "" {
+		claims["act"] = map[string]interface{}{"sub": jwt.Actor}
+	}
+
 	return claims
 }
@@ -0,0 +1,135 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"api.lnlink.net/src/pkg/errs"
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/services/jwk"
+	jwtv5 "github.com/golang-jwt/jwt/v5"
+)
+
+// a key we sign or verify with, kid is the RFC 7638 thumbprint of the public key
+type verificationKey struct {
+	kid    string
+	method jwtv5.SigningMethod
+	public crypto.PublicKey
+	jwk    jwk.Key
+}
+
+// the active key signs new tokens, every other key only verifies tokens signed before a rotation.
+// to rotate, move the old key file to JWT_VERIFICATION_KEY_FILES and point JWT_SIGNING_KEY_FILE
+// at the new one, once the old tokens expired the old file can be removed.
+// keys are PEM encoded Ed25519 or RSA keys e.g. `openssl genpkey -algorithm ed25519 -out jwt.pem`
+var signingKey crypto.Signer
+var signingKeyID string
+var verificationKeys = map[string]verificationKey{}
+var verificationKeyOrder = []string{}
+
+// parses a PEM file holding a private or public key
+func readKeyFile(path string) (crypto.PrivateKey, crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%s is not PEM encoded", path)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is not a signing key", path)
+		}
+		return private, signer.Public(), nil
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return private, private.Public(), nil
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, public, nil
+	}
+
+	return nil, nil, fmt.Errorf("%s has unsupported PEM block %s", path, block.Type)
+}
+
+func methodFor(public crypto.PublicKey) (jwtv5.SigningMethod, error) {
+	switch public.(type) {
+	case ed25519.PublicKey:
+		return jwtv5.SigningMethodEdDSA, nil
+	case *rsa.PublicKey:
+		return jwtv5.SigningMethodRS256, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T, use Ed25519 or RSA", public)
+}
+
+func addVerificationKey(public crypto.PublicKey) (string, error) {
+	method, err := methodFor(public)
+	if err != nil {
+		return "", err
+	}
+	key, err := jwk.FromPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+
+	if _, exists := verificationKeys[key.Kid]; !exists {
+		verificationKeyOrder = append(verificationKeyOrder, key.Kid)
+	}
+	verificationKeys[key.Kid] = verificationKey{
+		kid:    key.Kid,
+		method: method,
+		public: public,
+		jwk:    key,
+	}
+	return key.Kid, nil
+}
+
+// loads the signing and verification keys, call after global.Init
+func InitKeys() {
+	private, public, err := readKeyFile(global.CONFIG.JWT.SigningKeyFile)
+	errs.Invariant(err == nil, "can't read JWT signing key: %v", err)
+	errs.Invariant(private != nil, "JWT signing key file has no private key")
+
+	kid, err := addVerificationKey(public)
+	errs.Invariant(err == nil, "invalid JWT signing key: %v", err)
+
+	signingKey = private.(crypto.Signer)
+	signingKeyID = kid
+
+	for _, path := range global.CONFIG.JWT.VerificationKeyFiles {
+		_, public, err := readKeyFile(path)
+		errs.Invariant(err == nil, "can't read JWT verification key: %v", err)
+
+		_, err = addVerificationKey(public)
+		errs.Invariant(err == nil, "invalid JWT verification key %s: %v", path, err)
+	}
+}
+
+// the public keys other services use to validate our tokens, the signing key comes first
+func PublicKeySet() jwk.Set {
+	set := jwk.Set{Keys: []jwk.Key{}}
+	for _, kid := range verificationKeyOrder {
+		set.Keys = append(set.Keys, verificationKeys[kid].jwk)
+	}
+	return set
+}
@@ -0,0 +1,312 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	jwtv5 "github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the key state is package wide, every test starts without keys
+func resetKeys(t *testing.T) {
+	previous := global.CONFIG.JWT
+	t.Cleanup(func() { global.CONFIG.JWT = previous })
+
+	signingKey = nil
+	signingKeyID = ""
+	verificationKeys = map[string]verificationKey{}
+	verificationKeyOrder = []string{}
+}
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writePKCS8(t *testing.T, private crypto.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "PRIVATE KEY", der)
+}
+
+func writePublic(t *testing.T, public crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "PUBLIC KEY", der)
+}
+
+func generateEd25519(t *testing.T) ed25519.PrivateKey {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
+
+func generateRSA(t *testing.T) *rsa.PrivateKey {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
+
+func TestReadKeyFile(t *testing.T) {
+	edKey := generateEd25519(t)
+	rsaKey := generateRSA(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := filepath.Join(t.TempDir(), "plain.txt")
+	if err := os.WriteFile(plain, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		public      crypto.PublicKey
+		wantPrivate bool
+		wantErr     bool
+	}{
+		{name: "PKCS8 Ed25519", path: writePKCS8(t, edKey), public: edKey.Public(), wantPrivate: true},
+		{name: "PKCS8 RSA", path: writePKCS8(t, rsaKey), public: rsaKey.Public(), wantPrivate: true},
+		{name: "PKCS1 RSA", path: writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), public: rsaKey.Public(), wantPrivate: true},
+		{name: "public Ed25519", path: writePublic(t, edKey.Public()), public: edKey.Public()},
+		{name: "public RSA", path: writePublic(t, rsaKey.Public()), public: rsaKey.Public()},
+		{name: "EC private key", path: writePEM(t, "PRIVATE KEY", ecDER), public: ecKey.Public(), wantPrivate: true},
+		{name: "unsupported block", path: writePEM(t, "CERTIFICATE", []byte("x")), wantErr: true},
+		{name: "garbage in block", path: writePEM(t, "PRIVATE KEY", []byte("x")), wantErr: true},
+		{name: "not PEM", path: plain, wantErr: true},
+		{name: "missing file", path: filepath.Join(t.TempDir(), "missing.pem"), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			private, public, err := readKeyFile(test.path)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (private != nil) != test.wantPrivate {
+				t.Fatalf("private key = %v, want one: %v", private != nil, test.wantPrivate)
+			}
+			if !public.(interface{ Equal(crypto.PublicKey) bool }).Equal(test.public) {
+				t.Fatal("public key differs from the one written")
+			}
+		})
+	}
+}
+
+// readable but not usable for tokens, InitKeys refuses these
+func TestMethodForRejectsEC(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := methodFor(ecKey.Public()); err == nil {
+		t.Fatal("expected an error for an EC key")
+	}
+}
+
+// the example key from RFC 7638 section 3.1
+func TestKidIsRFC7638Thumbprint(t *testing.T) {
+	resetKeys(t)
+
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	kid, err := addVerificationKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; kid != want {
+		t.Fatalf("kid = %s, want %s", kid, want)
+	}
+}
+
+func TestInitKeys(t *testing.T) {
+	resetKeys(t)
+
+	current := generateEd25519(t)
+	previous := generateRSA(t)
+	global.CONFIG.JWT.SigningKeyFile = writePKCS8(t, current)
+	global.CONFIG.JWT.VerificationKeyFiles = []string{writePublic(t, previous.Public())}
+	InitKeys()
+
+	set := PublicKeySet()
+	if len(set.Keys) != 2 {
+		t.Fatalf("key set has %d keys, want 2", len(set.Keys))
+	}
+	if set.Keys[0].Kid != signingKeyID || set.Keys[0].Kty != "OKP" {
+		t.Fatalf("the signing key isn't first: %+v", set.Keys[0])
+	}
+	if set.Keys[1].Kty != "RSA" {
+		t.Fatalf("second key = %+v, want the RSA verification key", set.Keys[1])
+	}
+	for _, key := range set.Keys {
+		if key.Kid != key.Thumbprint() {
+			t.Fatalf("kid %s isn't the thumbprint of its key", key.Kid)
+		}
+	}
+}
+
+func TestCreateAndValidate(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		key  crypto.PrivateKey
+	}{
+		{"Ed25519", generateEd25519(t)},
+		{"RSA", generateRSA(t)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resetKeys(t)
+			global.CONFIG.JWT.SigningKeyFile = writePKCS8(t, test.key)
+			global.CONFIG.JWT.VerificationKeyFiles = nil
+			InitKeys()
+
+			userID := primitive.NewObjectID()
+			adminID := primitive.NewObjectID()
+			token, err := CreateImpersonationJWT(userID, adminID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			valid, parsed := ValidateJWT(token.Value)
+			if !valid {
+				t.Fatal("fresh token rejected")
+			}
+			if parsed.Claims.Subject != userID.Hex() || parsed.Claims.Actor != adminID.Hex() {
+				t.Fatalf("claims = %+v", parsed.Claims)
+			}
+		})
+	}
+}
+
+// tokens signed before a rotation stay valid until they expire
+func TestValidateAfterRotation(t *testing.T) {
+	resetKeys(t)
+	old := generateEd25519(t)
+	global.CONFIG.JWT.SigningKeyFile = writePKCS8(t, old)
+	InitKeys()
+
+	userID := primitive.NewObjectID()
+	oldToken, err := CreateJWT(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKid := signingKeyID
+
+	// the rotation as documented: a new signing key and the old file kept for verifying
+	oldFile := global.CONFIG.JWT.SigningKeyFile
+	resetKeys(t)
+	global.CONFIG.JWT.SigningKeyFile = writePKCS8(t, generateRSA(t))
+	global.CONFIG.JWT.VerificationKeyFiles = []string{oldFile}
+	InitKeys()
+
+	if signingKeyID == oldKid {
+		t.Fatal("still signing with the old key")
+	}
+	if valid, parsed := ValidateJWT(oldToken.Value); !valid || parsed.Claims.Subject != userID.Hex() {
+		t.Fatal("token signed with the rotated key rejected")
+	}
+	newToken, err := CreateJWT(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, _ := ValidateJWT(newToken.Value); !valid {
+		t.Fatal("token signed with the new key rejected")
+	}
+
+	// once the old file is removed its tokens stop working
+	resetKeys(t)
+	global.CONFIG.JWT.VerificationKeyFiles = nil
+	global.CONFIG.JWT.SigningKeyFile = writePKCS8(t, generateRSA(t))
+	InitKeys()
+	if valid, _ := ValidateJWT(oldToken.Value); valid {
+		t.Fatal("token accepted after its key was removed")
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	resetKeys(t)
+	key := generateEd25519(t)
+	global.CONFIG.JWT.SigningKeyFile = writePKCS8(t, key)
+	InitKeys()
+
+	sign := func(method jwtv5.SigningMethod, signer any, kid string, claims Claims) string {
+		token := jwtv5.NewWithClaims(method, claims.ToRawClaims())
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	now := time.Now()
+	claims := Claims{
+		Issuer:    DEFAULT_ISSUER,
+		Subject:   primitive.NewObjectID().Hex(),
+		Audience:  DEFAULT_AUDIENCE,
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		JWTID:     "id",
+	}
+	expired := claims
+	expired.ExpiresAt = now.Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"unknown kid", sign(jwtv5.SigningMethodEdDSA, key, "unknown", claims)},
+		{"another key under our kid", sign(jwtv5.SigningMethodEdDSA, generateEd25519(t), signingKeyID, claims)},
+		{"expired", sign(jwtv5.SigningMethodEdDSA, key, signingKeyID, expired)},
+		{"HS256 keyed with the public key", sign(jwtv5.SigningMethodHS256, []byte(key.Public().(ed25519.PublicKey)), signingKeyID, claims)},
+		{"garbage", "not.a.token"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if valid, _ := ValidateJWT(test.token); valid {
+				t.Fatal("token accepted")
+			}
+		})
+	}
+}
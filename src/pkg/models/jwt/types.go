@@ -11,6 +11,8 @@ type Claims struct {
 	IssuedAt  int64  `bson:"iat" json:"iat"`
 	NotBefore int64  `bson:"nbf" json:"nbf"`
 	JWTID     string `bson:"jti" json:"jti"`
+	// the admin acting as the subject during impersonation, sent as the RFC 8693 act claim
+	Actor string `bson:"act,omitempty" json:"act,omitempty"`
 }
 
 // its useful to store the value and the decoded claims
@@ -22,3 +24,6 @@ type Token struct {
 var DEFAULT_ISSUER = "api.lnlink.net"
 var DEFAULT_EXPIRATION_TIME = 12 * time.Hour
 var DEFAULT_AUDIENCE = "lnlink.net"
+
+// impersonation tokens can't be refreshed and are kept short
+var IMPERSONATION_EXPIRATION_TIME = time.Hour
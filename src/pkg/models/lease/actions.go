@@ -0,0 +1,121 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func collection() *mongo.Collection {
+	return global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(LeaseCollection)
+}
+
+// takes the lease for holder or extends it if holder already has it, false
+// when another holder has it and it hasn't expired yet
+func Acquire(name string, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"holder": holder},
+			bson.M{"expiresAt": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set":         bson.M{"holder": holder, "expiresAt": now.Add(ttl)},
+		"$setOnInsert": bson.M{"acquiredAt": now},
+	}
+
+	_, err := collection().UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	// the upsert collides with the existing lease of someone else
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("can't acquire lease %s: %v", name, err)
+	}
+	return true, nil
+}
+
+// gives the lease up early, only if holder still has it
+func Release(name string, holder string) error {
+	_, err := collection().DeleteOne(context.Background(), bson.M{"_id": name, "holder": holder})
+	if err != nil {
+		return fmt.Errorf("can't release lease %s: %v", name, err)
+	}
+	return nil
+}
+
+// Hold runs fn while holding the lease and renews it in the background.
+// the context is cancelled if the lease is lost, e.g. because renewing failed
+// for longer than the ttl. returns false without running fn when someone
+// else holds the lease, including another goroutine of this process
+func Hold(name string, ttl time.Duration, fn func(ctx context.Context)) (bool, error) {
+	return hold(name, newToken(), ttl, true, fn)
+}
+
+// Lead is Hold for periodic work, the lease is kept after fn returns so the
+// same replica stays the leader for as long as it keeps coming back within the ttl
+func Lead(name string, ttl time.Duration, fn func(ctx context.Context)) (bool, error) {
+	return hold(name, HOLDER, ttl, false, fn)
+}
+
+func hold(name string, holder string, ttl time.Duration, release bool, fn func(ctx context.Context)) (bool, error) {
+	acquired, err := Acquire(name, holder, ttl)
+	if err != nil || !acquired {
+		return false, err
+	}
+	if release {
+		defer func() {
+			if err := Release(name, holder); err != nil {
+				log.Printf("[Lease] %v", err)
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+	go heartbeat(name, holder, ttl, cancel, done)
+
+	fn(ctx)
+	return true, nil
+}
+
+func heartbeat(name string, holder string, ttl time.Duration, cancel context.CancelFunc, done chan struct{}) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	renewedAt := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			renewed, err := Acquire(name, holder, ttl)
+			if err != nil {
+				log.Printf("[Lease] %v", err)
+				// past the ttl someone else may already have taken over
+				if time.Since(renewedAt) > ttl {
+					cancel()
+					return
+				}
+				continue
+			}
+			if !renewed {
+				log.Printf("[Lease] Lost lease %s", name)
+				cancel()
+				return
+			}
+			renewedAt = time.Now()
+		}
+	}
+}
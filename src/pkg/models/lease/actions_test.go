@@ -0,0 +1,122 @@
+package lease
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"api.lnlink.net/src/pkg/mongotest"
+)
+
+func TestAcquire(t *testing.T) {
+	mongotest.Setup(t, "lease")
+
+	acquired, err := Acquire("work", "a", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("first acquire = %v, %v, want true", acquired, err)
+	}
+
+	acquired, err = Acquire("work", "b", time.Minute)
+	if err != nil || acquired {
+		t.Fatalf("acquire of a held lease = %v, %v, want false", acquired, err)
+	}
+
+	acquired, err = Acquire("other", "b", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("acquire of another lease = %v, %v, want true", acquired, err)
+	}
+}
+
+func TestAcquireAfterExpiry(t *testing.T) {
+	mongotest.Setup(t, "lease")
+
+	if acquired, err := Acquire("work", "a", 100*time.Millisecond); err != nil || !acquired {
+		t.Fatalf("first acquire = %v, %v, want true", acquired, err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	acquired, err := Acquire("work", "b", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("acquire of an expired lease = %v, %v, want true", acquired, err)
+	}
+
+	// the old holder can't extend a lease it lost
+	acquired, err = Acquire("work", "a", time.Minute)
+	if err != nil || acquired {
+		t.Fatalf("renewal by the old holder = %v, %v, want false", acquired, err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	mongotest.Setup(t, "lease")
+
+	Acquire("work", "a", time.Minute)
+	if err := Release("work", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, _ := Acquire("work", "b", time.Minute); acquired {
+		t.Fatal("releasing someone else's lease freed it")
+	}
+
+	if err := Release("work", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, _ := Acquire("work", "b", time.Minute); !acquired {
+		t.Fatal("released lease can't be acquired")
+	}
+}
+
+func TestHoldRenews(t *testing.T) {
+	mongotest.Setup(t, "lease")
+
+	ttl := 300 * time.Millisecond
+	held, err := Hold("work", ttl, func(ctx context.Context) {
+		// well past the ttl, the heartbeat keeps the lease
+		time.Sleep(3 * ttl)
+		if acquired, _ := Acquire("work", "other", ttl); acquired {
+			t.Error("lease expired while it was held")
+		}
+		if ctx.Err() != nil {
+			t.Error("context was cancelled while the lease was held")
+		}
+	})
+	if err != nil || !held {
+		t.Fatalf("Hold = %v, %v, want true", held, err)
+	}
+
+	// released once fn returned
+	if acquired, _ := Acquire("work", "other", ttl); !acquired {
+		t.Fatal("lease was not released")
+	}
+}
+
+func TestHoldIsNotReentrant(t *testing.T) {
+	mongotest.Setup(t, "lease")
+
+	held, err := Hold("work", time.Minute, func(ctx context.Context) {
+		nested, err := Hold("work", time.Minute, func(ctx context.Context) {
+			t.Error("second Hold ran while the first one held the lease")
+		})
+		if err != nil || nested {
+			t.Errorf("nested Hold = %v, %v, want false", nested, err)
+		}
+	})
+	if err != nil || !held {
+		t.Fatalf("Hold = %v, %v, want true", held, err)
+	}
+}
+
+func TestLeadKeepsLease(t *testing.T) {
+	mongotest.Setup(t, "lease")
+
+	for range 2 {
+		led, err := Lead("cron", time.Minute, func(ctx context.Context) {})
+		if err != nil || !led {
+			t.Fatalf("Lead = %v, %v, want true", led, err)
+		}
+	}
+
+	if acquired, _ := Acquire("cron", "other", time.Minute); acquired {
+		t.Fatal("another holder took over from the leader")
+	}
+}
@@ -0,0 +1,43 @@
+package lease
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+)
+
+var LeaseCollection = "leases"
+
+// a lock on a named piece of work, it lapses at ExpiresAt unless its
+// holder renews it, so a crashed replica can't keep work blocked
+type Lease struct {
+	Name       string    `bson:"_id" json:"name"`
+	Holder     string    `bson:"holder" json:"holder"`
+	AcquiredAt time.Time `bson:"acquiredAt" json:"acquiredAt"`
+	ExpiresAt  time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+// identifies this process among the replicas, leaders hold their leases as it
+var HOLDER = newHolder()
+
+func newHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), randomHex())
+}
+
+// every Hold gets a holder of its own, so two goroutines of the same
+// process can't both hold a lease
+func newToken() string {
+	return fmt.Sprintf("%s/%s", HOLDER, randomHex())
+}
+
+func randomHex() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hex.EncodeToString(suffix)
+}
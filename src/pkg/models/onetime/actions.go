@@ -0,0 +1,134 @@
+package onetime
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// generates a random url safe secret
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashes a secret for storage, secrets are random so sha256 is enough
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// issues a new token for a user and purpose and returns the raw value
+// any previous unused token for the same user and purpose is discarded
+func Issue(userID primitive.ObjectID, purpose Purpose, ttl time.Duration, payload string) (string, error) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OneTimeTokenCollection)
+	_, err = collection.DeleteMany(context.Background(), bson.M{
+		"userId":  userID,
+		"purpose": purpose,
+		"usedAt":  bson.M{"$exists": false},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = collection.InsertOne(context.Background(), OneTimeToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: HashSecret(secret),
+		Payload:   payload,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// marks a token as used and returns it
+// this is a single atomic update so a token can never be used twice
+func Consume(secret string, purpose Purpose) (*OneTimeToken, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OneTimeTokenCollection)
+
+	now := time.Now()
+	var token OneTimeToken
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{
+			"tokenHash": HashSecret(secret),
+			"purpose":   purpose,
+			"usedAt":    bson.M{"$exists": false},
+			"expiresAt": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"usedAt": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// returns a usable token without consuming it
+// used when the token only proves a first step and the second one can still fail
+func Lookup(secret string, purpose Purpose) (*OneTimeToken, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OneTimeTokenCollection)
+
+	var token OneTimeToken
+	err := collection.FindOne(context.Background(), bson.M{
+		"tokenHash": HashSecret(secret),
+		"purpose":   purpose,
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+		"attempts":  bson.M{"$not": bson.M{"$gte": MAX_ATTEMPTS}},
+	}).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// counts a wrong answer against a token, Lookup stops returning it after MAX_ATTEMPTS
+func (token *OneTimeToken) RecordFailedAttempt() error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OneTimeTokenCollection)
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": token.ID},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+	)
+	return err
+}
+
+// deletes every token of a user, used when their account is deleted
+func DeleteAllForUser(userID primitive.ObjectID) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OneTimeTokenCollection)
+	_, err := collection.DeleteMany(context.Background(), bson.M{"userId": userID})
+	return err
+}
@@ -0,0 +1,46 @@
+package onetime
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var OneTimeTokenCollection = "one_time_tokens"
+
+// one time tokens are emailed to users as links, we only store the hash
+// so a database leak doesn't let anyone verify emails or reset passwords
+type OneTimeToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	Purpose   Purpose            `bson:"purpose" json:"purpose"`
+	TokenHash string             `bson:"tokenHash" json:"-"`
+	// optional data bound to the token, e.g. the address being verified
+	Payload   string     `bson:"payload,omitempty" json:"payload,omitempty"`
+	Attempts  int        `bson:"attempts,omitempty" json:"attempts,omitempty"`
+	ExpiresAt time.Time  `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    *time.Time `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+}
+
+type Purpose string
+
+const (
+	PurposeEmailVerification Purpose = "EMAIL_VERIFICATION"
+	PurposePasswordReset     Purpose = "PASSWORD_RESET"
+	PurposeLoginChallenge    Purpose = "LOGIN_CHALLENGE"
+	PurposeSSOLogin          Purpose = "SSO_LOGIN"
+	PurposeEmailChange       Purpose = "EMAIL_CHANGE"
+)
+
+var EMAIL_VERIFICATION_EXPIRATION_TIME = 48 * time.Hour
+var PASSWORD_RESET_EXPIRATION_TIME = 30 * time.Minute
+var LOGIN_CHALLENGE_EXPIRATION_TIME = 5 * time.Minute
+var SSO_LOGIN_EXPIRATION_TIME = 2 * time.Minute
+var EMAIL_CHANGE_EXPIRATION_TIME = 24 * time.Hour
+
+// tokens that guard a second factor are burned after this many wrong answers
+var MAX_ATTEMPTS = 5
+
+var ErrInvalidToken = errors.New("token is invalid, expired or already used")
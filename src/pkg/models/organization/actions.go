@@ -0,0 +1,177 @@
+package organization
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/stripe"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// creates an organization with the user as its owner
+func Create(owner *user.User, name string) (*Organization, error) {
+	if owner.InOrganization() {
+		return nil, ErrAlreadyMember
+	}
+
+	org := Organization{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		ModelType: owner.ModelType,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	stripeCustomerID, err := stripe.CreateOrganizationCustomer(name, owner.Email, org.ID.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("can't create stripe customer: %v", err)
+	}
+	org.StripeCustomerID = stripeCustomerID
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OrganizationCollection)
+	if _, err := collection.InsertOne(context.Background(), org); err != nil {
+		return nil, fmt.Errorf("can't create organization: %v", err)
+	}
+
+	if err := owner.SetOrganization(org.ID, user.OrganizationOwner); err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+func GetByID(organizationID primitive.ObjectID) *Organization {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OrganizationCollection)
+
+	var org Organization
+	err := collection.FindOne(context.Background(), bson.M{"_id": organizationID}).Decode(&org)
+	if err != nil {
+		return nil
+	}
+
+	return &org
+}
+
+func GetByStripeCustomerID(stripeCustomerID string) *Organization {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OrganizationCollection)
+
+	var org Organization
+	err := collection.FindOne(context.Background(), bson.M{"stripeCustomerID": stripeCustomerID}).Decode(&org)
+	if err != nil {
+		return nil
+	}
+
+	return &org
+}
+
+// the organization a user belongs to, nil when they use their personal wallet
+func GetForUser(u *user.User) *Organization {
+	if u == nil || u.OrganizationID == nil {
+		return nil
+	}
+	return GetByID(*u.OrganizationID)
+}
+
+// adds or removes tokens from the shared wallet
+// atomically adds to the balance, negative amounts can't take it below zero
+func (org *Organization) AddTokens(tokens int) error {
+	filter := bson.M{"_id": org.ID}
+	if tokens < 0 {
+		filter["tokensAvailable"] = bson.M{"$gte": -tokens}
+	}
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OrganizationCollection)
+	var updated Organization
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		filter,
+		bson.M{
+			"$inc": bson.M{"tokensAvailable": tokens},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return ErrInsufficientTokens
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update organization tokens: %v", err)
+	}
+
+	org.TokensAvailable = updated.TokensAvailable
+	return nil
+}
+
+// adds purchased tokens once per checkout session, returns false if the session
+// was already credited
+func (org *Organization) CreditPurchase(checkoutSessionID string, tokens int) (bool, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OrganizationCollection)
+	var updated Organization
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": org.ID, "checkoutSessionIds": bson.M{"$ne": checkoutSessionID}},
+		bson.M{
+			"$inc":  bson.M{"tokensAvailable": tokens},
+			"$push": bson.M{"checkoutSessionIds": checkoutSessionID},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to credit organization: %v", err)
+	}
+
+	org.TokensAvailable = updated.TokensAvailable
+	return true, nil
+}
+
+// takes tokens for a completed image, unlike AddTokens it can go below zero
+// since the balance was already checked when the image was submitted
+func (org *Organization) Charge(tokens int) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OrganizationCollection)
+	var updated Organization
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": org.ID},
+		bson.M{
+			"$inc": bson.M{"tokensAvailable": -tokens},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to charge organization: %v", err)
+	}
+
+	org.TokensAvailable = updated.TokensAvailable
+	return nil
+}
+
+func (org *Organization) Members() ([]user.User, error) {
+	return user.GetUsersByOrganization(org.ID)
+}
+
+// counts the owners, an organization must always keep at least one
+func (org *Organization) OwnerCount() (int, error) {
+	members, err := org.Members()
+	if err != nil {
+		return 0, err
+	}
+
+	owners := 0
+	for _, member := range members {
+		if member.OrganizationRole == user.OrganizationOwner {
+			owners++
+		}
+	}
+	return owners, nil
+}
@@ -0,0 +1,90 @@
+package organization
+
+import (
+	"context"
+	"testing"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/mongotest"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAddTokensKeepsBalanceAboveZero(t *testing.T) {
+	mongotest.Setup(t, "organization")
+
+	org := Organization{ID: primitive.NewObjectID(), Name: "Lab", TokensAvailable: 5}
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OrganizationCollection)
+	if _, err := collection.InsertOne(context.Background(), org); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		amount  int
+		balance int
+		err     error
+	}{
+		{-3, 2, nil},
+		{-3, 2, ErrInsufficientTokens},
+		{-2, 0, nil},
+		{-1, 0, ErrInsufficientTokens},
+		{10, 10, nil},
+	}
+
+	for _, test := range tests {
+		if err := org.AddTokens(test.amount); err != test.err {
+			t.Fatalf("AddTokens(%d) = %v, want %v", test.amount, err, test.err)
+		}
+		if stored := GetByID(org.ID); stored.TokensAvailable != test.balance || org.TokensAvailable != test.balance {
+			t.Fatalf("after AddTokens(%d) balance = %d stored, %d in memory, want %d", test.amount, stored.TokensAvailable, org.TokensAvailable, test.balance)
+		}
+	}
+}
+
+func TestChargeCanOverdraw(t *testing.T) {
+	mongotest.Setup(t, "organization")
+
+	org := Organization{ID: primitive.NewObjectID(), Name: "Lab", TokensAvailable: 10}
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OrganizationCollection)
+	if _, err := collection.InsertOne(context.Background(), org); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, balance := range []int{-6, -22} {
+		if err := org.Charge(16); err != nil {
+			t.Fatalf("Charge(16) = %v", err)
+		}
+		if stored := GetByID(org.ID); stored.TokensAvailable != balance || org.TokensAvailable != balance {
+			t.Fatalf("after Charge(16) balance = %d stored, %d in memory, want %d", stored.TokensAvailable, org.TokensAvailable, balance)
+		}
+	}
+}
+
+func TestCreditPurchaseOncePerSession(t *testing.T) {
+	mongotest.Setup(t, "organization")
+
+	org := Organization{ID: primitive.NewObjectID(), Name: "Lab"}
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(OrganizationCollection)
+	if _, err := collection.InsertOne(context.Background(), org); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		session  string
+		credited bool
+		balance  int
+	}{
+		{"cs_1", true, 100},
+		{"cs_1", false, 100},
+		{"cs_2", true, 200},
+	}
+
+	for _, test := range tests {
+		credited, err := org.CreditPurchase(test.session, 100)
+		if err != nil || credited != test.credited {
+			t.Fatalf("CreditPurchase(%s) = %v, %v, want %v", test.session, credited, err, test.credited)
+		}
+		if stored := GetByID(org.ID); stored.TokensAvailable != test.balance {
+			t.Fatalf("after CreditPurchase(%s) balance = %d, want %d", test.session, stored.TokensAvailable, test.balance)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package organization
+
+import (
+	"errors"
+	"time"
+
+	"api.lnlink.net/src/pkg/models/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var OrganizationCollection = "organizations"
+
+// a lab or team that shares one token wallet, membership is stored on the users
+type Organization struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name             string             `bson:"name" json:"name"`
+	StripeCustomerID string             `bson:"stripeCustomerID" json:"stripeCustomerID"`
+	TokensAvailable  int                `bson:"tokensAvailable" json:"tokensAvailable"`
+	// checkout sessions that were credited, stripe can deliver a webhook more than once
+	CheckoutSessionIDs []string  `bson:"checkoutSessionIds,omitempty" json:"-"`
+	ModelType          string    `bson:"modelType" json:"modelType"`
+	CreatedAt          time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt          time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// used for creating an organization
+type CreateOrganization struct {
+	Name string `json:"name"`
+}
+
+// used for changing a member's role
+type UpdateMember struct {
+	Role user.OrganizationRole `json:"role"`
+}
+
+// used by admins to change the shared wallet, the reason ends up in the audit log
+type AdminAdjustTokens struct {
+	Amount int    `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+var ErrAlreadyMember = errors.New("user is already in an organization")
+var ErrInsufficientTokens = errors.New("insufficient tokens")
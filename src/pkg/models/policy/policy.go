@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"api.lnlink.net/src/pkg/models/experiments"
+	"api.lnlink.net/src/pkg/models/user"
+)
+
+type Permission string
+
+const (
+	ViewExperiments    Permission = "experiments:view"
+	SubmitExperiments  Permission = "experiments:submit"
+	PurchaseTokens     Permission = "tokens:purchase"
+	ManageOrganization Permission = "organization:manage"
+	Administer         Permission = "platform:administer"
+)
+
+// what each organization role may do, platform admins may do everything
+var ORGANIZATION_ROLE_PERMISSIONS = map[user.OrganizationRole][]Permission{
+	user.OrganizationOwner:  {ViewExperiments, SubmitExperiments, PurchaseTokens, ManageOrganization},
+	user.OrganizationMember: {ViewExperiments, SubmitExperiments, PurchaseTokens},
+	user.OrganizationViewer: {ViewExperiments},
+}
+
+// users outside of an organization own their personal workspace
+var PERSONAL_PERMISSIONS = ORGANIZATION_ROLE_PERMISSIONS[user.OrganizationOwner]
+
+func permissionsFor(u *user.User) []Permission {
+	if u.InOrganization() {
+		return ORGANIZATION_ROLE_PERMISSIONS[u.OrganizationRole]
+	}
+	return PERSONAL_PERMISSIONS
+}
+
+func Can(u *user.User, permission Permission) bool {
+	if u == nil {
+		return false
+	}
+	if u.IsAdmin() {
+		return true
+	}
+
+	for _, p := range permissionsFor(u) {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// submitters always see their experiments, organization experiments are
+// visible to every member that can view experiments
+func CanViewExperiment(u *user.User, exp *experiments.MultiExperiment) bool {
+	if u == nil || exp == nil {
+		return false
+	}
+	if u.IsAdmin() || exp.UserID == u.ID {
+		return true
+	}
+
+	sameOrganization := exp.OrganizationID != nil && u.OrganizationID != nil && *exp.OrganizationID == *u.OrganizationID
+	return sameOrganization && Can(u, ViewExperiments)
+}
+
+// submitters can change their own experiments while they can still submit,
+// organization owners can change every experiment of their organization
+func CanModifyExperiment(u *user.User, exp *experiments.MultiExperiment) bool {
+	if u == nil || exp == nil {
+		return false
+	}
+	if u.IsAdmin() {
+		return true
+	}
+	if exp.UserID == u.ID {
+		return Can(u, SubmitExperiments)
+	}
+
+	sameOrganization := exp.OrganizationID != nil && u.OrganizationID != nil && *exp.OrganizationID == *u.OrganizationID
+	return sameOrganization && Can(u, ManageOrganization)
+}
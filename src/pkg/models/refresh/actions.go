@@ -0,0 +1,108 @@
+package refresh
+
+import (
+	"context"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/onetime"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// issues a refresh token and returns it along with the raw value
+// an empty familyID starts a new family i.e. a new login
+func Issue(userID primitive.ObjectID, familyID string) (*RefreshToken, string, error) {
+	secret, err := onetime.GenerateSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if familyID == "" {
+		familyID = uuid.New().String()
+	}
+
+	now := time.Now()
+	token := RefreshToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: onetime.HashSecret(secret),
+		ExpiresAt: now.Add(DEFAULT_EXPIRATION_TIME),
+		CreatedAt: now,
+	}
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(RefreshTokenCollection)
+	if _, err := collection.InsertOne(context.Background(), token); err != nil {
+		return nil, "", err
+	}
+
+	return &token, secret, nil
+}
+
+// exchanges a refresh token for a new one in the same family
+// returns the consumed token so the caller knows who it belongs to
+func Rotate(secret string) (*RefreshToken, string, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(RefreshTokenCollection)
+	hash := onetime.HashSecret(secret)
+
+	now := time.Now()
+	var token RefreshToken
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{
+			"tokenHash": hash,
+			"rotatedAt": bson.M{"$exists": false},
+			"revokedAt": bson.M{"$exists": false},
+			"expiresAt": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"rotatedAt": now}},
+	).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		// the token exists but can't be used, if it was rotated someone is replaying it
+		var stale RefreshToken
+		if collection.FindOne(context.Background(), bson.M{"tokenHash": hash}).Decode(&stale) == nil {
+			if stale.RotatedAt != nil {
+				if err := RevokeFamily(stale.FamilyID); err != nil {
+					return nil, "", err
+				}
+				return nil, "", ErrTokenReused
+			}
+		}
+		return nil, "", ErrInvalidToken
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, next, err := Issue(token.UserID, token.FamilyID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &token, next, nil
+}
+
+// revokes every token descending from the same login
+func RevokeFamily(familyID string) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(RefreshTokenCollection)
+	_, err := collection.UpdateMany(
+		context.Background(),
+		bson.M{"familyId": familyID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	return err
+}
+
+// revokes every refresh token of a user, used when the password changes
+func RevokeAllForUser(userID primitive.ObjectID) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(RefreshTokenCollection)
+	_, err := collection.UpdateMany(
+		context.Background(),
+		bson.M{"userId": userID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	return err
+}
@@ -0,0 +1,29 @@
+package refresh
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var RefreshTokenCollection = "refresh_tokens"
+
+// refresh tokens are rotated on every use, every token descends from one login
+// and shares its FamilyID. presenting an already rotated token means it was stolen,
+// so we revoke the whole family and both the thief and the user have to log in again
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	FamilyID  string             `bson:"familyId" json:"familyId"`
+	TokenHash string             `bson:"tokenHash" json:"-"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"`
+	RotatedAt *time.Time         `bson:"rotatedAt,omitempty" json:"rotatedAt,omitempty"`
+	RevokedAt *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+var DEFAULT_EXPIRATION_TIME = 30 * 24 * time.Hour
+
+var ErrInvalidToken = errors.New("refresh token is invalid or expired")
+var ErrTokenReused = errors.New("refresh token was already used")
@@ -0,0 +1,331 @@
+package sso
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
+
+func providers() *mongo.Collection {
+	return global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(ProviderCollection)
+}
+
+// slugs are unique and so are domains, an email can only ever lead to one provider
+func EnsureIndexes() error {
+	_, err := providers().Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "domains", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		return fmt.Errorf("can't create sso provider indexes: %v", err)
+	}
+	return nil
+}
+
+// domains are matched against lowercased email domains
+func NormalizeDomains(domains []string) []string {
+	normalized := []string{}
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" && !slices.Contains(normalized, domain) {
+			normalized = append(normalized, domain)
+		}
+	}
+	return normalized
+}
+
+// checks the settings in the shape they are stored in, problems wrap ErrInvalidProvider
+func (provider *Provider) Validate() error {
+	if !SLUG_PATTERN.MatchString(provider.Slug) {
+		return fmt.Errorf("%w: slug may only contain lowercase letters, digits and dashes", ErrInvalidProvider)
+	}
+	if provider.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidProvider)
+	}
+	issuer, err := url.Parse(provider.Issuer)
+	if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+		return fmt.Errorf("%w: issuer must be an http(s) URL", ErrInvalidProvider)
+	}
+	if provider.ClientID == "" {
+		return fmt.Errorf("%w: client ID is required", ErrInvalidProvider)
+	}
+	if len(provider.Domains) == 0 {
+		return fmt.Errorf("%w: at least one domain is required", ErrInvalidProvider)
+	}
+	for _, domain := range provider.Domains {
+		if !strings.Contains(domain, ".") || strings.ContainsAny(domain, "@/ ") {
+			return fmt.Errorf("%w: invalid domain %s", ErrInvalidProvider, domain)
+		}
+	}
+	return nil
+}
+
+// builds a provider from the request, it is enabled right away
+func NewProvider(body CreateProvider) *Provider {
+	now := time.Now()
+	return &Provider{
+		Slug:          strings.ToLower(strings.TrimSpace(body.Slug)),
+		Name:          strings.TrimSpace(body.Name),
+		Issuer:        strings.TrimSpace(body.Issuer),
+		ClientID:      strings.TrimSpace(body.ClientID),
+		ClientSecret:  body.ClientSecret,
+		Scopes:        body.Scopes,
+		Domains:       NormalizeDomains(body.Domains),
+		AutoProvision: body.AutoProvision,
+		Enabled:       true,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// stores a provider built by NewProvider
+func (provider *Provider) Insert() error {
+	if err := provider.Validate(); err != nil {
+		return err
+	}
+
+	result, err := providers().InsertOne(context.Background(), provider)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrProviderTaken
+	}
+	if err != nil {
+		return fmt.Errorf("can't create sso provider: %v", err)
+	}
+
+	provider.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// the providers of an organization or every provider when it is nil, including disabled ones
+func ListProviders(organizationID *primitive.ObjectID) ([]Provider, error) {
+	filter := bson.M{}
+	if organizationID != nil {
+		filter["organizationId"] = *organizationID
+	}
+
+	cursor, err := providers().Find(context.Background(), filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	list := []Provider{}
+	if err := cursor.All(context.Background(), &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// finds a provider by slug, enabled or not
+func FindProvider(slug string) (*Provider, error) {
+	var provider Provider
+	err := providers().FindOne(context.Background(), bson.M{"slug": slug}).Decode(&provider)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrProviderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &provider, nil
+}
+
+// finds a provider of an organization, enabled or not
+func GetProvider(organizationID primitive.ObjectID, providerID primitive.ObjectID) (*Provider, error) {
+	var provider Provider
+	err := providers().FindOne(context.Background(), bson.M{"_id": providerID, "organizationId": organizationID}).Decode(&provider)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrProviderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &provider, nil
+}
+
+// applies the fields that were sent, nothing is stored if the result is invalid
+func (provider *Provider) Update(body UpdateProvider) error {
+	updated := *provider
+	if body.Name != nil {
+		updated.Name = strings.TrimSpace(*body.Name)
+	}
+	if body.Issuer != nil {
+		updated.Issuer = strings.TrimSpace(*body.Issuer)
+	}
+	if body.ClientID != nil {
+		updated.ClientID = strings.TrimSpace(*body.ClientID)
+	}
+	if body.ClientSecret != nil {
+		updated.ClientSecret = *body.ClientSecret
+	}
+	if body.Scopes != nil {
+		updated.Scopes = *body.Scopes
+	}
+	if body.Domains != nil {
+		updated.Domains = NormalizeDomains(*body.Domains)
+	}
+	if body.AutoProvision != nil {
+		updated.AutoProvision = *body.AutoProvision
+	}
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+	updated.UpdatedAt = time.Now()
+
+	_, err := providers().UpdateOne(context.Background(), bson.M{"_id": provider.ID}, bson.M{"$set": bson.M{
+		"name":          updated.Name,
+		"issuer":        updated.Issuer,
+		"clientId":      updated.ClientID,
+		"clientSecret":  updated.ClientSecret,
+		"scopes":        updated.Scopes,
+		"domains":       updated.Domains,
+		"autoProvision": updated.AutoProvision,
+		"updatedAt":     updated.UpdatedAt,
+	}})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrProviderTaken
+	}
+	if err != nil {
+		return fmt.Errorf("can't update sso provider: %v", err)
+	}
+
+	*provider = updated
+	return nil
+}
+
+// disabled providers can't be used to sign in, accounts linked to them stay
+func (provider *Provider) SetEnabled(enabled bool) error {
+	_, err := providers().UpdateOne(context.Background(),
+		bson.M{"_id": provider.ID},
+		bson.M{"$set": bson.M{"enabled": enabled, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("can't update sso provider: %v", err)
+	}
+
+	provider.Enabled = enabled
+	return nil
+}
+
+// everything but the client secret, which is write only
+func (provider *Provider) AdminView() map[string]any {
+	return map[string]any{
+		"id":              provider.ID,
+		"organizationId":  provider.OrganizationID,
+		"slug":            provider.Slug,
+		"name":            provider.Name,
+		"issuer":          provider.Issuer,
+		"clientId":        provider.ClientID,
+		"hasClientSecret": provider.ClientSecret != "",
+		"scopes":          provider.GetScopes(),
+		"domains":         provider.Domains,
+		"autoProvision":   provider.AutoProvision,
+		"enabled":         provider.Enabled,
+		"createdAt":       provider.CreatedAt,
+		"updatedAt":       provider.UpdatedAt,
+	}
+}
+
+func GetProviderBySlug(slug string) (*Provider, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(ProviderCollection)
+
+	var provider Provider
+	err := collection.FindOne(context.Background(), bson.M{"slug": slug, "enabled": true}).Decode(&provider)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrProviderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &provider, nil
+}
+
+// finds the provider responsible for an email's domain
+func GetProviderForEmail(email string) (*Provider, error) {
+	domain := emailDomain(email)
+	if domain == "" {
+		return nil, ErrProviderNotFound
+	}
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(ProviderCollection)
+
+	var provider Provider
+	err := collection.FindOne(context.Background(), bson.M{"domains": domain, "enabled": true}).Decode(&provider)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrProviderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &provider, nil
+}
+
+// only addresses in the provider's domains can be linked or provisioned through it
+func (provider *Provider) OwnsEmail(email string) bool {
+	domain := emailDomain(email)
+	if domain == "" {
+		return false
+	}
+	for _, d := range provider.Domains {
+		if strings.ToLower(d) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func (provider *Provider) GetScopes() []string {
+	if len(provider.Scopes) == 0 {
+		return DEFAULT_SCOPES
+	}
+	return provider.Scopes
+}
+
+func CreateLoginState(state *LoginState) error {
+	now := time.Now()
+	state.CreatedAt = now
+	state.ExpiresAt = now.Add(LOGIN_STATE_EXPIRATION_TIME)
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(LoginStateCollection)
+	_, err := collection.InsertOne(context.Background(), state)
+	return err
+}
+
+// looks up and deletes a login state so every state can only be used once
+func ConsumeLoginState(state string) (*LoginState, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(LoginStateCollection)
+
+	var loginState LoginState
+	err := collection.FindOneAndDelete(context.Background(), bson.M{
+		"state":     state,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}).Decode(&loginState)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidState
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &loginState, nil
+}
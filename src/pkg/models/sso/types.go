@@ -0,0 +1,80 @@
+package sso
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ProviderCollection = "sso_providers"
+var LoginStateCollection = "sso_login_states"
+
+// an OpenID Connect identity provider, users whose email matches one of
+// the Domains are offered this provider when they sign in
+type Provider struct {
+	ID primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	// the organization the provider signs in for, accounts it provisions join it
+	OrganizationID primitive.ObjectID `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
+	Slug           string             `bson:"slug" json:"slug"`
+	Name           string             `bson:"name" json:"name"`
+	Issuer         string             `bson:"issuer" json:"issuer"`
+	ClientID       string             `bson:"clientId" json:"-"`
+	ClientSecret   string             `bson:"clientSecret,omitempty" json:"-"`
+	Scopes         []string           `bson:"scopes,omitempty" json:"-"`
+	Domains        []string           `bson:"domains" json:"domains"`
+	// create accounts for unknown users instead of rejecting them
+	AutoProvision bool      `bson:"autoProvision" json:"-"`
+	Enabled       bool      `bson:"enabled" json:"-"`
+	CreatedAt     time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt     time.Time `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+}
+
+type CreateProvider struct {
+	Slug          string   `json:"slug"`
+	Name          string   `json:"name"`
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"clientId"`
+	ClientSecret  string   `json:"clientSecret"`
+	Scopes        []string `json:"scopes"`
+	Domains       []string `json:"domains"`
+	AutoProvision bool     `json:"autoProvision"`
+}
+
+// fields that are left out keep their value, the slug is fixed since it is
+// part of the redirect URI registered at the provider
+type UpdateProvider struct {
+	Name          *string   `json:"name"`
+	Issuer        *string   `json:"issuer"`
+	ClientID      *string   `json:"clientId"`
+	ClientSecret  *string   `json:"clientSecret"`
+	Scopes        *[]string `json:"scopes"`
+	Domains       *[]string `json:"domains"`
+	AutoProvision *bool     `json:"autoProvision"`
+}
+
+// slugs end up in the redirect URI registered at the provider
+var SLUG_PATTERN = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// scopes requested when the provider doesn't configure any
+var DEFAULT_SCOPES = []string{"openid", "email", "profile"}
+
+// everything we need to finish a login once the provider redirects back,
+// looked up by the state parameter and deleted on use
+type LoginState struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	State        string             `bson:"state"`
+	ProviderSlug string             `bson:"providerSlug"`
+	Nonce        string             `bson:"nonce"`
+	CodeVerifier string             `bson:"codeVerifier"`
+	ExpiresAt    time.Time          `bson:"expiresAt"`
+	CreatedAt    time.Time          `bson:"createdAt"`
+}
+
+var LOGIN_STATE_EXPIRATION_TIME = 10 * time.Minute
+
+var ErrProviderNotFound = errors.New("sso provider not found")
+var ErrInvalidProvider = errors.New("invalid sso provider")
+var ErrProviderTaken = errors.New("slug or domain is already used by another sso provider")
+var ErrInvalidState = errors.New("sso login state is invalid or expired")
@@ -0,0 +1,126 @@
+package throttle
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func policyFor(kind Kind) Policy {
+	if kind == KindIP {
+		return IP_POLICY
+	}
+	return ACCOUNT_POLICY
+}
+
+// how long to wait after the given number of failures in a row
+func (policy Policy) Delay(failures int) time.Duration {
+	if failures <= policy.FreeAttempts {
+		return 0
+	}
+	// a large shift overflows to zero or below, the cap covers that too
+	delay := policy.BaseDelay << (failures - policy.FreeAttempts - 1)
+	if delay > policy.MaxDelay || delay <= 0 {
+		delay = policy.MaxDelay
+	}
+	return delay
+}
+
+// whether the given number of failures in a row locks the key
+func (policy Policy) Locks(failures int) bool {
+	return failures >= policy.LockAfter
+}
+
+// account keys are emails, normalized so case tricks don't get fresh counters
+func AccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// returns how long the caller has to wait before trying again, zero means go ahead
+func Check(kind Kind, key string) (time.Duration, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(LoginThrottleCollection)
+
+	var throttle LoginThrottle
+	err := collection.FindOne(context.Background(), bson.M{"kind": kind, "key": key}).Decode(&throttle)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	wait := time.Duration(0)
+	if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+		wait = throttle.LockedUntil.Sub(now)
+	} else if throttle.NextAttemptAt != nil && throttle.NextAttemptAt.After(now) {
+		wait = throttle.NextAttemptAt.Sub(now)
+	}
+
+	return wait, nil
+}
+
+// counts a failed attempt and schedules the next allowed one
+// returns true when this failure locked the key
+func RecordFailure(kind Kind, key string) (*LoginThrottle, bool, error) {
+	policy := policyFor(kind)
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(LoginThrottleCollection)
+
+	// a pipeline update so the reset and the increment happen atomically
+	now := time.Now()
+	var throttle LoginThrottle
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"kind": kind, "key": key},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"kind": kind,
+			"key":  key,
+			"failures": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$lastFailureAt", now.Add(-policy.ResetAfter)}},
+				bson.M{"$add": bson.A{"$failures", 1}},
+				1,
+			}},
+			"lastFailureAt": now,
+		}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&throttle)
+	if err != nil {
+		return nil, false, err
+	}
+
+	set := bson.M{}
+	if delay := policy.Delay(throttle.Failures); delay > 0 {
+		nextAttemptAt := now.Add(delay)
+		throttle.NextAttemptAt = &nextAttemptAt
+		set["nextAttemptAt"] = nextAttemptAt
+	}
+
+	locked := false
+	if policy.Locks(throttle.Failures) && (throttle.LockedUntil == nil || throttle.LockedUntil.Before(now)) {
+		lockedUntil := now.Add(policy.LockDuration)
+		throttle.LockedUntil = &lockedUntil
+		set["lockedUntil"] = lockedUntil
+		locked = true
+	}
+
+	if len(set) > 0 {
+		_, err = collection.UpdateOne(context.Background(), bson.M{"_id": throttle.ID}, bson.M{"$set": set})
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return &throttle, locked, nil
+}
+
+// clears the counters, used after a successful login and by admins to unlock
+func Reset(kind Kind, key string) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(LoginThrottleCollection)
+	_, err := collection.DeleteOne(context.Background(), bson.M{"kind": kind, "key": key})
+	return err
+}
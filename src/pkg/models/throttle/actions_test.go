@@ -0,0 +1,156 @@
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/mongotest"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDelay(t *testing.T) {
+	policy := Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+
+	tests := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 16 * time.Second},
+		{9, 30 * time.Second},
+		{20, 30 * time.Second},
+		// shifts past the width of a Duration wrap to zero
+		{3 + 64, 30 * time.Second},
+		{3 + 100, 30 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := policy.Delay(test.failures); got != test.delay {
+			t.Errorf("Delay(%d) = %v, want %v", test.failures, got, test.delay)
+		}
+	}
+}
+
+func TestDelayNeverExceedsMax(t *testing.T) {
+	for _, policy := range []Policy{ACCOUNT_POLICY, IP_POLICY} {
+		previous := time.Duration(0)
+		for failures := 0; failures <= 200; failures++ {
+			delay := policy.Delay(failures)
+			if delay < previous || delay > policy.MaxDelay {
+				t.Fatalf("Delay(%d) = %v after %v, max %v", failures, delay, previous, policy.MaxDelay)
+			}
+			previous = delay
+		}
+	}
+}
+
+func TestLocks(t *testing.T) {
+	tests := []struct {
+		kind     Kind
+		failures int
+		locks    bool
+	}{
+		{KindAccount, 9, false},
+		{KindAccount, 10, true},
+		{KindAccount, 11, true},
+		{KindIP, 10, false},
+		{KindIP, 49, false},
+		{KindIP, 50, true},
+	}
+
+	for _, test := range tests {
+		if got := policyFor(test.kind).Locks(test.failures); got != test.locks {
+			t.Errorf("%s Locks(%d) = %v, want %v", test.kind, test.failures, got, test.locks)
+		}
+	}
+}
+
+// the counters live in mongo, see mongotest. the policy is swapped for one that locks quickly
+func setup(t *testing.T) {
+	mongotest.Setup(t, "throttle")
+
+	previous := ACCOUNT_POLICY
+	ACCOUNT_POLICY = Policy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, LockAfter: 4, LockDuration: time.Hour, ResetAfter: time.Hour}
+
+	t.Cleanup(func() {
+		ACCOUNT_POLICY = previous
+	})
+}
+
+func TestRecordFailure(t *testing.T) {
+	setup(t)
+	key := AccountKey(" Someone@Example.com ")
+
+	for failures := 1; failures <= 4; failures++ {
+		throttle, locked, err := RecordFailure(KindAccount, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if throttle.Failures != failures {
+			t.Fatalf("failures = %d, want %d", throttle.Failures, failures)
+		}
+		if locked != (failures == 4) {
+			t.Fatalf("locked after %d failures = %v", failures, locked)
+		}
+
+		wait, err := Check(KindAccount, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case failures <= 2 && wait != 0:
+			t.Fatalf("wait after %d failures = %v, want none", failures, wait)
+		case failures == 3 && (wait <= 0 || wait > time.Minute):
+			t.Fatalf("wait after 3 failures = %v, want up to a minute", wait)
+		case failures == 4 && wait <= 2*time.Minute:
+			t.Fatalf("wait after locking = %v, want the lock duration", wait)
+		}
+	}
+
+	// further failures don't push an active lock out
+	throttle, locked, err := RecordFailure(KindAccount, key)
+	if err != nil || locked {
+		t.Fatalf("failure while locked = %v, %v, want no new lock", locked, err)
+	}
+	if throttle.LockedUntil == nil || time.Until(*throttle.LockedUntil) > time.Hour {
+		t.Fatalf("lock was extended to %v", throttle.LockedUntil)
+	}
+
+	if err := Reset(KindAccount, key); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := Check(KindAccount, key); wait != 0 {
+		t.Fatalf("wait after reset = %v", wait)
+	}
+}
+
+func TestRecordFailureStartsOverAfterQuietPeriod(t *testing.T) {
+	setup(t)
+	key := AccountKey("someone@example.com")
+
+	RecordFailure(KindAccount, key)
+	RecordFailure(KindAccount, key)
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(LoginThrottleCollection)
+	_, err := collection.UpdateOne(context.Background(), bson.M{"kind": KindAccount, "key": key},
+		bson.M{"$set": bson.M{"lastFailureAt": time.Now().Add(-2 * time.Hour)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	throttle, _, err := RecordFailure(KindAccount, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if throttle.Failures != 1 {
+		t.Fatalf("failures = %d, want the count to start over", throttle.Failures)
+	}
+}
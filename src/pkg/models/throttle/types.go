@@ -0,0 +1,59 @@
+package throttle
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var LoginThrottleCollection = "login_throttles"
+
+// failed login bookkeeping for one account or one IP
+// it lives in mongo so every replica sees the same counts
+type LoginThrottle struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Kind          Kind               `bson:"kind" json:"kind"`
+	Key           string             `bson:"key" json:"key"`
+	Failures      int                `bson:"failures" json:"failures"`
+	LastFailureAt time.Time          `bson:"lastFailureAt" json:"lastFailureAt"`
+	NextAttemptAt *time.Time         `bson:"nextAttemptAt,omitempty" json:"nextAttemptAt,omitempty"`
+	LockedUntil   *time.Time         `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+}
+
+type Kind string
+
+const (
+	KindAccount Kind = "ACCOUNT"
+	KindIP      Kind = "IP"
+)
+
+// after FreeAttempts failures every attempt has to wait BaseDelay, doubling up to MaxDelay,
+// after LockAfter failures the key is locked for LockDuration.
+// counts start over when there was no failure for ResetAfter
+type Policy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int
+	LockDuration time.Duration
+	ResetAfter   time.Duration
+}
+
+var ACCOUNT_POLICY = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     30 * time.Second,
+	LockAfter:    10,
+	LockDuration: 15 * time.Minute,
+	ResetAfter:   time.Hour,
+}
+
+// an office or lab can share one address, so IPs get more room
+var IP_POLICY = Policy{
+	FreeAttempts: 10,
+	BaseDelay:    time.Second,
+	MaxDelay:     30 * time.Second,
+	LockAfter:    50,
+	LockDuration: 30 * time.Minute,
+	ResetAfter:   time.Hour,
+}
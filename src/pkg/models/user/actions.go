@@ -2,18 +2,57 @@ package user
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log"
+	"regexp"
+	"strings"
 	"time"
 
 	"api.lnlink.net/src/pkg/errs"
 	"api.lnlink.net/src/pkg/global"
-	"api.lnlink.net/src/pkg/models/jwt"
+	"api.lnlink.net/src/pkg/models/onetime"
+	"api.lnlink.net/src/pkg/models/refresh"
 	"api.lnlink.net/src/pkg/services/stripe"
+	"api.lnlink.net/src/pkg/services/totp"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// emails are stored and looked up lowercased, the unique index on them then
+// also rejects the same address in a different case
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// lowercases emails stored before they were normalized and makes them unique.
+// fails if two accounts only differ in the case of their email, those have
+// to be merged by hand
+func EnsureIndexes() error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+
+	_, err := collection.UpdateMany(context.Background(),
+		bson.M{"$expr": bson.M{"$ne": bson.A{"$email", bson.M{"$toLower": "$email"}}}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"email": bson.M{"$toLower": "$email"}}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("can't lowercase emails: %v", err)
+	}
+
+	_, err = collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("can't create unique email index: %v", err)
+	}
+	return nil
+}
+
 // creates a user, nothing to do with the auth
 func CreateUser(userAuth *UserAuth, modelType string) User {
 	hash, err := bcrypt.GenerateFromPassword([]byte(userAuth.Password), bcrypt.DefaultCost)
@@ -22,9 +61,9 @@ func CreateUser(userAuth *UserAuth, modelType string) User {
 	errs.Invariant(err == nil, "can't create stripe customer")
 
 	user := User{
-		Email:            userAuth.Email,
+		Email:            NormalizeEmail(userAuth.Email),
 		PasswordHash:     string(hash),
-		ActiveTokens:     []jwt.Token{},
+		Sessions:         []Session{},
 		StripeCustomerID: stripe_customer_id,
 		TokensAvailable:  10,
 		ModelType:        modelType,
@@ -32,7 +71,7 @@ func CreateUser(userAuth *UserAuth, modelType string) User {
 		UpdatedAt:        time.Now(),
 	}
 
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(UserCollection)
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
 	result, err := collection.InsertOne(context.Background(), user)
 	errs.Invariant(err == nil, "can't create user", err)
 
@@ -41,9 +80,189 @@ func CreateUser(userAuth *UserAuth, modelType string) User {
 	return user
 }
 
+// hashes the password and sets up billing for a new user, the caller stores it
+func buildUser(email string, password string) (User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, fmt.Errorf("can't hash password: %v", err)
+	}
+	stripe_customer_id, err := stripe.CreateCustomer(email)
+	if err != nil {
+		return User{}, fmt.Errorf("can't create stripe customer: %v", err)
+	}
+
+	return User{
+		Email:            NormalizeEmail(email),
+		PasswordHash:     string(hash),
+		Sessions:         []Session{},
+		StripeCustomerID: stripe_customer_id,
+		TokensAvailable:  10,
+		ModelType:        DEFAULT_MODEL_TYPE,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}, nil
+}
+
+func insertUser(user *User) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	result, err := collection.InsertOne(context.Background(), user)
+	// someone registered the address since it was checked
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return fmt.Errorf("can't create user: %v", err)
+	}
+
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// registers a user that has to verify their email before they can log in.
+// checking first saves a stripe customer for taken addresses, the index still
+// decides between concurrent signups
+func RegisterUser(userAuth *UserAuth) (*User, error) {
+	if existing := GetUserByEmail(userAuth.Email); existing != nil {
+		return nil, ErrEmailTaken
+	}
+
+	user, err := buildUser(userAuth.Email, userAuth.Password)
+	if err != nil {
+		return nil, err
+	}
+	user.PendingVerification = true
+
+	if err := insertUser(&user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// creates a user from an accepted invitation, the emailed link already proved
+// they own the address so there's nothing left to verify
+func CreateInvitedUser(id primitive.ObjectID, email string, password string, organizationID primitive.ObjectID, role OrganizationRole) (*User, error) {
+	if existing := GetUserByEmail(email); existing != nil {
+		return nil, ErrEmailTaken
+	}
+
+	user, err := buildUser(email, password)
+	if err != nil {
+		return nil, err
+	}
+	// a member from the insert on, there is never an account that used the invitation without joining
+	user.ID = id
+	user.OrganizationID = &organizationID
+	user.OrganizationRole = role
+
+	if err := insertUser(&user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// creates a user that signs in through an identity provider
+// they get a random password, they can still set one through password reset
+func CreateSSOUser(email string, provider string, subject string) (*User, error) {
+	if existing := GetUserByEmail(email); existing != nil {
+		return nil, ErrEmailTaken
+	}
+
+	password, err := onetime.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := buildUser(email, password)
+	if err != nil {
+		return nil, err
+	}
+	user.SSOIdentities = []SSOIdentity{{Provider: provider, Subject: subject, LinkedAt: time.Now()}}
+
+	if err := insertUser(&user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func GetUserBySSOIdentity(provider string, subject string) *User {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+
+	var user User
+	err := collection.FindOne(context.Background(), bson.M{
+		"ssoIdentities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+	}).Decode(&user)
+	if err != nil {
+		return nil
+	}
+
+	return &user
+}
+
+// links an identity provider subject to an existing account
+func (user *User) LinkSSOIdentity(provider string, subject string) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$push": bson.M{"ssoIdentities": SSOIdentity{Provider: provider, Subject: subject, LinkedAt: time.Now()}},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to link sso identity: %v", err)
+	}
+
+	return nil
+}
+
+// activates a self registered account
+func (user *User) MarkVerified() error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$unset": bson.M{"pendingVerification": ""},
+			"$set":   bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to verify user: %v", err)
+	}
+
+	user.PendingVerification = false
+	return nil
+}
+
+// switches the address once the new one was confirmed, the stripe customer is the caller's job.
+// the unique index decides whether the address is still free
+func (user *User) ChangeEmail(newEmail string) error {
+	newEmail = NormalizeEmail(newEmail)
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"email": newEmail, "updatedAt": time.Now()}},
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to change email: %v", err)
+	}
+
+	user.Email = newEmail
+	return nil
+}
+
 // get a user by their ID
 func GetUserByID(userID primitive.ObjectID) *User {
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(UserCollection)
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
 
 	var user User
 	err := collection.FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user)
@@ -54,8 +273,20 @@ func GetUserByID(userID primitive.ObjectID) *User {
 	return &user
 }
 
+func GetUserByEmail(email string) *User {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+
+	var user User
+	err := collection.FindOne(context.Background(), bson.M{"email": NormalizeEmail(email)}).Decode(&user)
+	if err != nil {
+		return nil
+	}
+
+	return &user
+}
+
 func GetUserByStripeCustomerID(stripeCustomerID string) *User {
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(UserCollection)
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
 
 	var user User
 	err := collection.FindOne(context.Background(), bson.M{"stripeCustomerID": stripeCustomerID}).Decode(&user)
@@ -66,14 +297,76 @@ func GetUserByStripeCustomerID(stripeCustomerID string) *User {
 	return &user
 }
 
-func (user *User) AddTokens(tokens int) {
-	user = GetUserByID(user.ID)
+// atomically adds to the balance, negative amounts can't take it below zero
+// returns the new balance
+func (user *User) AdjustTokens(amount int) (int, error) {
+	filter := bson.M{"_id": user.ID}
+	if amount < 0 {
+		filter["tokensAvailable"] = bson.M{"$gte": -amount}
+	}
 
-	user.TokensAvailable += tokens
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	var updated User
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		filter,
+		bson.M{"$inc": bson.M{"tokensAvailable": amount}, "$set": bson.M{"updatedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return 0, ErrInsufficientTokens
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to update user tokens: %v", err)
+	}
 
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(UserCollection)
-	_, err := collection.UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"tokensAvailable": user.TokensAvailable}})
-	errs.Invariant(err == nil, "can't update user")
+	user.TokensAvailable = updated.TokensAvailable
+	return updated.TokensAvailable, nil
+}
+
+// adds purchased tokens once per checkout session, returns false if the session
+// was already credited
+func (user *User) CreditPurchase(checkoutSessionID string, tokens int) (bool, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	var updated User
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": user.ID, "checkoutSessionIds": bson.M{"$ne": checkoutSessionID}},
+		bson.M{
+			"$inc":  bson.M{"tokensAvailable": tokens},
+			"$push": bson.M{"checkoutSessionIds": checkoutSessionID},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to credit user: %v", err)
+	}
+
+	user.TokensAvailable = updated.TokensAvailable
+	return true, nil
+}
+
+// takes tokens for a completed image, unlike AdjustTokens it can go below zero
+// since the balance was already checked when the image was submitted
+func (user *User) Charge(tokens int) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	var updated User
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$inc": bson.M{"tokensAvailable": -tokens}, "$set": bson.M{"updatedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err != nil {
+		return fmt.Errorf("failed to charge user: %v", err)
+	}
+
+	user.TokensAvailable = updated.TokensAvailable
+	return nil
 }
 
 // DeductTokens deducts tokens from the user's available balance
@@ -86,7 +379,7 @@ func (user *User) DeductTokens(tokens int) error {
 
 	user.TokensAvailable -= tokens
 
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(UserCollection)
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
 	_, err := collection.UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"tokensAvailable": user.TokensAvailable}})
 	if err != nil {
 		return fmt.Errorf("failed to update user tokens: %v", err)
@@ -95,12 +388,98 @@ func (user *User) DeductTokens(tokens int) error {
 	return nil
 }
 
+// lists the members of an organization, oldest accounts first
+func GetUsersByOrganization(organizationID primitive.ObjectID) ([]User, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+
+	cursor, err := collection.Find(context.Background(),
+		bson.M{"organizationId": organizationID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	users := []User{}
+	if err := cursor.All(context.Background(), &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (user *User) IsAdmin() bool {
+	return user.Role == RoleAdmin
+}
+
+// grants or revokes a platform role, an empty role makes a regular user
+func (user *User) SetRole(role Role) error {
+	update := bson.M{"$set": bson.M{"role": role, "updatedAt": time.Now()}}
+	if role == "" {
+		update = bson.M{"$unset": bson.M{"role": ""}, "$set": bson.M{"updatedAt": time.Now()}}
+	}
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	_, err := collection.UpdateOne(context.Background(), bson.M{"_id": user.ID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update role: %v", err)
+	}
+
+	user.Role = role
+	return nil
+}
+
+func (user *User) InOrganization() bool {
+	return user.OrganizationID != nil
+}
+
+// puts the user in an organization, or changes their role in it
+func (user *User) SetOrganization(organizationID primitive.ObjectID, role OrganizationRole) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{
+			"organizationId":   organizationID,
+			"organizationRole": role,
+			"updatedAt":        time.Now(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update organization membership: %v", err)
+	}
+
+	user.OrganizationID = &organizationID
+	user.OrganizationRole = role
+	return nil
+}
+
+func (user *User) LeaveOrganization() error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$unset": bson.M{"organizationId": "", "organizationRole": ""},
+			"$set":   bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to leave organization: %v", err)
+	}
+
+	user.OrganizationID = nil
+	user.OrganizationRole = ""
+	return nil
+}
+
 // only check password, no JWT
 func AuthenticateUser(userAuth *UserAuth) (bool, *User) {
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(UserCollection)
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
 
 	var user User
-	err := collection.FindOne(context.Background(), bson.M{"email": userAuth.Email}).Decode(&user)
+	err := collection.FindOne(context.Background(), bson.M{"email": NormalizeEmail(userAuth.Email)}).Decode(&user)
 	if err != nil {
 		return false, nil
 	}
@@ -113,51 +492,138 @@ func AuthenticateUser(userAuth *UserAuth) (bool, *User) {
 	return true, &user
 }
 
-func (user *User) AddActiveToken(jwt *jwt.Token) {
-	user = GetUserByID(user.ID)
+// starts a session for a freshly issued access token
+// expired sessions are dropped at the same time
+func (user *User) AddSession(session Session) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$pull": bson.M{"sessions": bson.M{"expiresAt": bson.M{"$lte": time.Now()}}}},
+	)
+	errs.Invariant(err == nil, "can't update user")
 
-	user.ActiveTokens = append(user.ActiveTokens, *jwt)
+	_, err = collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$push": bson.M{"sessions": session}},
+	)
+	errs.Invariant(err == nil, "can't update user")
+}
+
+// finds a session by the jti of its access token
+func (user *User) GetSession(sessionID string) *Session {
+	now := time.Now()
+	for i := range user.Sessions {
+		if user.Sessions[i].ID == sessionID && user.Sessions[i].ExpiresAt.After(now) {
+			return &user.Sessions[i]
+		}
+	}
+	return nil
+}
 
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(UserCollection)
+// records that a session was used, at most once per SESSION_TOUCH_INTERVAL
+// so we don't write to the user on every request
+func (user *User) TouchSession(sessionID string, ip string, userAgent string) {
+	now := time.Now()
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
 	_, err := collection.UpdateOne(
 		context.Background(),
-		bson.M{"_id": user.ID},
-		bson.M{"$set": bson.M{"activeTokens": user.ActiveTokens}},
+		bson.M{
+			"_id": user.ID,
+			"sessions": bson.M{"$elemMatch": bson.M{
+				"id":         sessionID,
+				"lastSeenAt": bson.M{"$lt": now.Add(-SESSION_TOUCH_INTERVAL)},
+			}},
+		},
+		bson.M{"$set": bson.M{
+			"sessions.$.lastSeenAt": now,
+			"sessions.$.ip":         ip,
+			"sessions.$.userAgent":  userAgent,
+		}},
+	)
+	if err != nil {
+		log.Printf("Failed to touch session %s: %v", sessionID, err)
+	}
+}
+
+// moves a session to the access token issued by a refresh
+// returns false if the session was revoked in the meantime
+func (user *User) RotateSession(refreshFamilyID string, sessionID string, ip string, userAgent string) bool {
+	now := time.Now()
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	result, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID, "sessions.refreshFamilyId": refreshFamilyID},
+		bson.M{"$set": bson.M{
+			"sessions.$.id":         sessionID,
+			"sessions.$.lastSeenAt": now,
+			"sessions.$.expiresAt":  now.Add(refresh.DEFAULT_EXPIRATION_TIME),
+			"sessions.$.ip":         ip,
+			"sessions.$.userAgent":  userAgent,
+		}},
 	)
 	errs.Invariant(err == nil, "can't update user")
+
+	return result.MatchedCount == 1
 }
 
-// checks based on a string, thats why we keep them
-// we automatically remove expired tokens
-func (user *User) IsTokenActive(token string) bool {
+// removes the matching sessions and revokes their refresh tokens
+// so they can't come back. returns how many sessions were removed
+func (user *User) removeSessions(remove func(session Session) bool) (int, error) {
 	user = GetUserByID(user.ID)
+	if user == nil {
+		return 0, ErrUserNotFound
+	}
 
-	now := time.Now()
-	activeTokens := make([]jwt.Token, 0)
-	tokenFound := false
-
-	for _, t := range user.ActiveTokens {
-		if len(t.Value) > 0 && t.Claims.ExpiresAt > now.Unix() {
-			activeTokens = append(activeTokens, t)
-			if t.Value == token {
-				tokenFound = true
+	removedIDs := []string{}
+	for _, session := range user.Sessions {
+		if !remove(session) {
+			continue
+		}
+		removedIDs = append(removedIDs, session.ID)
+
+		// nothing is removed yet, so a retry revokes the rest
+		if session.RefreshFamilyID != "" {
+			if err := refresh.RevokeFamily(session.RefreshFamilyID); err != nil {
+				return 0, fmt.Errorf("can't revoke refresh tokens: %v", err)
 			}
 		}
 	}
+	if len(removedIDs) == 0 {
+		return 0, nil
+	}
 
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(UserCollection)
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
 	_, err := collection.UpdateOne(
 		context.Background(),
 		bson.M{"_id": user.ID},
-		bson.M{"$set": bson.M{"activeTokens": activeTokens}},
+		bson.M{"$pull": bson.M{"sessions": bson.M{"id": bson.M{"$in": removedIDs}}}},
 	)
-	errs.Invariant(err == nil, "can't update user")
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove sessions: %v", err)
+	}
 
-	return tokenFound
+	return len(removedIDs), nil
+}
+
+// removes one session, used to logout of one device
+func (user *User) RemoveSession(sessionID string) (bool, error) {
+	removed, err := user.removeSessions(func(session Session) bool {
+		return session.ID == sessionID
+	})
+	return removed > 0, err
+}
+
+// removes every session except the one making the request
+func (user *User) RemoveOtherSessions(sessionID string) (int, error) {
+	return user.removeSessions(func(session Session) bool {
+		return session.ID != sessionID
+	})
 }
 
 // changes the password of a user
-// also invalidates all active tokens
+// also invalidates all sessions and refresh tokens
 func (user *User) ChangePassword(newPassword string) {
 	user = GetUserByID(user.ID)
 
@@ -167,36 +633,321 @@ func (user *User) ChangePassword(newPassword string) {
 	user.PasswordHash = string(hash)
 	user.UpdatedAt = time.Now()
 
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(UserCollection)
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
 	_, err = collection.UpdateOne(
 		context.Background(),
 		bson.M{"_id": user.ID},
 		bson.M{"$set": bson.M{
 			"passwordHash": string(hash),
 			"updatedAt":    time.Now(),
-			"activeTokens": []jwt.Claims{},
+			"sessions":     []Session{},
 		}},
 	)
 	errs.Invariant(err == nil, "can't update user")
+
+	err = refresh.RevokeAllForUser(user.ID)
+	errs.Invariant(err == nil, "can't revoke refresh tokens")
 }
 
-// removes an active token from a user
-// used to logout of one device
-func (user *User) RemoveActiveToken(token string) {
+func (user *User) HasTwoFactor() bool {
+	return user.TwoFactor != nil && user.TwoFactor.Enabled
+}
+
+// stores a fresh TOTP secret that becomes active once ConfirmTwoFactor gets a valid code
+// starting over replaces any unconfirmed secret
+func (user *User) BeginTwoFactorEnrollment() (string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	result, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID, "twoFactor.enabled": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{
+			"twoFactor": TwoFactor{Secret: secret, RecoveryCodeHashes: []string{}},
+			"updatedAt": time.Now(),
+		}},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to start 2FA enrollment: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return "", ErrTwoFactorEnabled
+	}
+
+	return secret, nil
+}
+
+// enables 2FA after checking the first code, returns the raw recovery codes
+func (user *User) ConfirmTwoFactor(code string) ([]string, error) {
 	user = GetUserByID(user.ID)
 
-	user.ActiveTokens = make([]jwt.Token, 0)
-	for _, t := range user.ActiveTokens {
-		if t.Value != token {
-			user.ActiveTokens = append(user.ActiveTokens, t)
+	if user.TwoFactor == nil {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	if user.TwoFactor.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	step, ok := totp.Validate(user.TwoFactor.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	_, err = collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{
+			"twoFactor.enabled":            true,
+			"twoFactor.enabledAt":          now,
+			"twoFactor.lastUsedStep":       step,
+			"twoFactor.recoveryCodeHashes": hashes,
+			"updatedAt":                    now,
+		}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable 2FA: %v", err)
+	}
+
+	return codes, nil
+}
+
+// checks a TOTP or recovery code, both can only be used once
+func (user *User) VerifyTwoFactor(code string) bool {
+	user = GetUserByID(user.ID)
+	if user == nil || !user.HasTwoFactor() {
+		return false
+	}
+
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+
+	// the step filter makes sure the same code can't be replayed within its window
+	if step, ok := totp.Validate(user.TwoFactor.Secret, code, time.Now()); ok {
+		result, err := collection.UpdateOne(
+			context.Background(),
+			bson.M{"_id": user.ID, "twoFactor.lastUsedStep": bson.M{"$lt": step}},
+			bson.M{"$set": bson.M{"twoFactor.lastUsedStep": step}},
+		)
+		return err == nil && result.ModifiedCount == 1
+	}
+
+	result, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$pull": bson.M{"twoFactor.recoveryCodeHashes": onetime.HashSecret(normalizeRecoveryCode(code))}},
+	)
+	return err == nil && result.ModifiedCount == 1
+}
+
+func (user *User) DisableTwoFactor() error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$unset": bson.M{"twoFactor": ""},
+			"$set":   bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to disable 2FA: %v", err)
+	}
+
+	user.TwoFactor = nil
+	return nil
+}
+
+// recovery codes look like 1a2b-3c4d
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, RECOVERY_CODE_COUNT)
+	hashes := make([]string, 0, RECOVERY_CODE_COUNT)
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		buf := make([]byte, 4)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
 		}
+		raw := hex.EncodeToString(buf)
+		code := raw[:4] + "-" + raw[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, onetime.HashSecret(normalizeRecoveryCode(code)))
 	}
+	return codes, hashes, nil
+}
 
-	collection := global.MONGO_CLIENT.Database(global.MONGO_DB_NAME).Collection(UserCollection)
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// strips everything personal from the user document and signs them out everywhere,
+// the document itself stays so references to the user id don't dangle
+func (user *User) Anonymize() error {
+	if err := refresh.RevokeAllForUser(user.ID); err != nil {
+		return fmt.Errorf("can't revoke refresh tokens: %v", err)
+	}
+
+	now := time.Now()
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
 	_, err := collection.UpdateOne(
 		context.Background(),
 		bson.M{"_id": user.ID},
-		bson.M{"$set": bson.M{"activeTokens": user.ActiveTokens}},
+		bson.M{
+			"$set": bson.M{
+				"email":            fmt.Sprintf("deleted-%s@deleted.invalid", user.ID.Hex()),
+				"passwordHash":     "",
+				"sessions":         []Session{},
+				"stripeCustomerID": "",
+				"tokensAvailable":  0,
+				"deletedAt":        now,
+				"updatedAt":        now,
+			},
+			"$unset": bson.M{
+				"pendingVerification": "",
+				"twoFactor":           "",
+				"ssoIdentities":       "",
+				"organizationId":      "",
+				"organizationRole":    "",
+				"role":                "",
+			},
+		},
 	)
-	errs.Invariant(err == nil, "can't update user")
+	if err != nil {
+		return fmt.Errorf("can't anonymize user: %v", err)
+	}
+
+	user.DeletedAt = &now
+	return nil
+}
+
+// signs the user out of every device, used by admins
+func (user *User) RemoveAllSessions() (int, error) {
+	return user.removeSessions(func(session Session) bool { return true })
+}
+
+func (user *User) IsDisabled() bool {
+	return user.DisabledAt != nil
+}
+
+// blocks sign in and signs the user out everywhere
+func (user *User) Disable(reason string) error {
+	now := time.Now()
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"disabledAt": now, "disabledReason": reason, "updatedAt": now}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to disable user: %v", err)
+	}
+
+	user.DisabledAt = &now
+	user.DisabledReason = reason
+	if _, err := user.RemoveAllSessions(); err != nil {
+		return fmt.Errorf("user is disabled but still signed in: %v", err)
+	}
+	return nil
+}
+
+func (user *User) Enable() error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$unset": bson.M{"disabledAt": "", "disabledReason": ""},
+			"$set":   bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to enable user: %v", err)
+	}
+
+	user.DisabledAt = nil
+	user.DisabledReason = ""
+	return nil
+}
+
+func (user *User) SetModelType(modelType string) error {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"modelType": modelType, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update model type: %v", err)
+	}
+
+	user.ModelType = modelType
+	return nil
+}
+
+// finds users by a part of their email or their exact ID, newest first
+// deleted accounts are left out
+func SearchUsers(query string, page int, pageSize int) ([]User, int64, error) {
+	collection := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(UserCollection)
+
+	filter := bson.M{"deletedAt": bson.M{"$exists": false}}
+	if query != "" {
+		matches := bson.A{bson.M{"email": bson.M{"$regex": regexp.QuoteMeta(query), "$options": "i"}}}
+		if id, err := primitive.ObjectIDFromHex(query); err == nil {
+			matches = append(matches, bson.M{"_id": id})
+		}
+		filter["$or"] = matches
+	}
+
+	total, err := collection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := collection.Find(context.Background(),
+		filter,
+		options.Find().
+			SetSkip(int64((page-1)*pageSize)).
+			SetLimit(int64(pageSize)).
+			SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	users := []User{}
+	if err := cursor.All(context.Background(), &users); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// what admins get to see of a user, credentials stay out
+func (user *User) AdminView() map[string]any {
+	return map[string]any{
+		"id":                  user.ID,
+		"email":               user.Email,
+		"pendingVerification": user.PendingVerification,
+		"twoFactorEnabled":    user.HasTwoFactor(),
+		"ssoIdentities":       user.SSOIdentities,
+		"stripeCustomerID":    user.StripeCustomerID,
+		"tokensAvailable":     user.TokensAvailable,
+		"modelType":           user.ModelType,
+		"organizationId":      user.OrganizationID,
+		"organizationRole":    user.OrganizationRole,
+		"role":                user.Role,
+		"disabledAt":          user.DisabledAt,
+		"disabledReason":      user.DisabledReason,
+		"sessions":            user.Sessions,
+		"createdAt":           user.CreatedAt,
+		"updatedAt":           user.UpdatedAt,
+	}
 }
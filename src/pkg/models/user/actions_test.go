@@ -0,0 +1,71 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"api.lnlink.net/src/pkg/mongotest"
+	"api.lnlink.net/src/pkg/services/totp"
+)
+
+func userWithTwoFactor(t *testing.T) (*User, []string) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &User{
+		Email:     "two-factor@example.com",
+		Sessions:  []Session{},
+		TwoFactor: &TwoFactor{Secret: secret, Enabled: true, RecoveryCodeHashes: hashes},
+	}
+	if err := insertUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user, codes
+}
+
+func TestVerifyTwoFactorRejectsReplay(t *testing.T) {
+	mongotest.Setup(t, "user")
+	user, _ := userWithTwoFactor(t)
+
+	code, err := totp.Code(user.TwoFactor.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !user.VerifyTwoFactor(code) {
+		t.Fatal("fresh code rejected")
+	}
+	if user.VerifyTwoFactor(code) {
+		t.Fatal("the same code was accepted twice")
+	}
+
+	// a code from an earlier step is still inside the skew window but older than the one used
+	previous, _ := totp.Code(user.TwoFactor.Secret, time.Now().Add(-totp.PERIOD))
+	if previous != code && user.VerifyTwoFactor(previous) {
+		t.Fatal("an older code was accepted after a newer one")
+	}
+}
+
+func TestVerifyTwoFactorRecoveryCodesAreSingleUse(t *testing.T) {
+	mongotest.Setup(t, "user")
+	user, codes := userWithTwoFactor(t)
+
+	if !user.VerifyTwoFactor(codes[0]) {
+		t.Fatal("recovery code rejected")
+	}
+	if user.VerifyTwoFactor(codes[0]) {
+		t.Fatal("recovery code was accepted twice")
+	}
+	if !user.VerifyTwoFactor(codes[1]) {
+		t.Fatal("another recovery code rejected after one was used")
+	}
+	if user.VerifyTwoFactor("0000-0000") {
+		t.Fatal("made up recovery code accepted")
+	}
+}
@@ -1,41 +1,215 @@
 package user
 
 import (
+	"errors"
 	"time"
 
-	"api.lnlink.net/src/pkg/models/jwt"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var UserCollection = "users"
 
-// we keep track of active tokens to prevent token reuse
-// for example, if a user changes their password, we can invalidate all their active tokens
-// TODO: implement login persistance via refresh tokens i.e. we issue a refresh token as a http-only cookie
-// and we use it to issue new access tokens upon expiration.
-// TODO: implement a registration flow i.e. we need to verify the email address
+// model type given to self registered users
+var DEFAULT_MODEL_TYPE = "innocent"
+
+// model types an account can be switched to
+var MODEL_TYPES = []string{"innocent"}
+
+// minimum length for new passwords
+var MIN_PASSWORD_LENGTH = 8
+
+// we keep track of sessions to prevent token reuse
+// for example, if a user changes their password, we can invalidate all their sessions
+// login persistence is handled by rotating refresh tokens (see models/refresh) kept in a http-only cookie,
+// they are used to issue new access tokens upon expiration.
+// self registered users start with PendingVerification set until they confirm their email,
+// it's a negative flag so that accounts created before registration existed stay valid
 type User struct {
-	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Email        string             `bson:"email" json:"email"`
-	PasswordHash string             `bson:"passwordHash" json:"passwordHash"`
-	ActiveTokens []jwt.Token        `bson:"activeTokens" json:"activeTokens"`
+	ID                  primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Email               string             `bson:"email" json:"email"`
+	PasswordHash        string             `bson:"passwordHash" json:"passwordHash"`
+	Sessions            []Session          `bson:"sessions" json:"sessions"`
+	PendingVerification bool               `bson:"pendingVerification,omitempty" json:"pendingVerification,omitempty"`
+	TwoFactor           *TwoFactor         `bson:"twoFactor,omitempty" json:"-"`
+	SSOIdentities       []SSOIdentity      `bson:"ssoIdentities,omitempty" json:"ssoIdentities,omitempty"`
 
 	StripeCustomerID string `bson:"stripeCustomerID" json:"stripeCustomerID"`
 	TokensAvailable  int    `bson:"tokensAvailable" json:"tokensAvailable"`
 	ModelType        string `bson:"modelType" json:"modelType"`
+	// checkout sessions that were credited, stripe can deliver a webhook more than once
+	CheckoutSessionIDs []string `bson:"checkoutSessionIds,omitempty" json:"-"`
+
+	// members of an organization spend and buy tokens from its wallet instead of their own
+	OrganizationID   *primitive.ObjectID `bson:"organizationId,omitempty" json:"organizationId,omitempty"`
+	OrganizationRole OrganizationRole    `bson:"organizationRole,omitempty" json:"organizationRole,omitempty"`
+
+	// empty for regular users
+	Role Role `bson:"role,omitempty" json:"role,omitempty"`
+
+	// disabled accounts can't sign in or use their API keys
+	DisabledAt     *time.Time `bson:"disabledAt,omitempty" json:"disabledAt,omitempty"`
+	DisabledReason string     `bson:"disabledReason,omitempty" json:"disabledReason,omitempty"`
+
+	// set once the account was deleted, the document stays anonymized so that
+	// organization experiments keep pointing at something
+	DeletedAt *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
 
 	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
 	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
 }
 
+type OrganizationRole string
+
+const (
+	OrganizationOwner  OrganizationRole = "OWNER"
+	OrganizationMember OrganizationRole = "MEMBER"
+	OrganizationViewer OrganizationRole = "VIEWER"
+)
+
+// platform wide role, independent of any organization
+type Role string
+
+const (
+	RoleAdmin Role = "ADMIN"
+)
+
+// one login on one device, keyed by the jti of its current access token
+// so the bearer token itself is never stored. logins from the web app also carry
+// a refresh token family which moves the session to each new access token
+type Session struct {
+	ID              string    `bson:"id" json:"id"`
+	RefreshFamilyID string    `bson:"refreshFamilyId,omitempty" json:"-"`
+	CreatedAt       time.Time `bson:"createdAt" json:"createdAt"`
+	LastSeenAt      time.Time `bson:"lastSeenAt" json:"lastSeenAt"`
+	ExpiresAt       time.Time `bson:"expiresAt" json:"expiresAt"`
+	IP              string    `bson:"ip" json:"ip"`
+	UserAgent       string    `bson:"userAgent" json:"userAgent"`
+	// the admin who opened this session to act as the user
+	ImpersonatedBy *primitive.ObjectID `bson:"impersonatedBy,omitempty" json:"impersonatedBy,omitempty"`
+}
+
+// how stale LastSeenAt can get before a request updates it
+var SESSION_TOUCH_INTERVAL = time.Minute
+
+// TOTP second factor, the secret has to be kept as is to compute codes
+// Enabled stays false between enrollment and the first valid code
+type TwoFactor struct {
+	Secret             string     `bson:"secret"`
+	Enabled            bool       `bson:"enabled"`
+	RecoveryCodeHashes []string   `bson:"recoveryCodeHashes"`
+	LastUsedStep       int64      `bson:"lastUsedStep"`
+	EnabledAt          *time.Time `bson:"enabledAt,omitempty"`
+}
+
+// links an account to a subject at an OpenID Connect provider
+type SSOIdentity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"subject"`
+	LinkedAt time.Time `bson:"linkedAt" json:"linkedAt"`
+}
+
+// number of single use recovery codes handed out when 2FA is enabled
+var RECOVERY_CODE_COUNT = 10
+
 // used for login and create account
 type UserAuth struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+// used for confirming an email address
+type UserVerifyEmail struct {
+	Token string `json:"token"`
+}
+
+// used for resending the verification email
+type UserResendVerification struct {
+	Email string `json:"email"`
+}
+
+// used for requesting a password reset email
+type UserForgotPassword struct {
+	Email string `json:"email"`
+}
+
+// used for setting a new password with an emailed token
+type UserResetPassword struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
 // used for changing password
 type UserChangePassword struct {
 	OldPassword string `json:"oldPassword"`
 	NewPassword string `json:"newPassword"`
+	Code        string `json:"code"`
+}
+
+// used for trading an SSO login token for an access token
+type UserSSOExchange struct {
+	Token string `json:"token"`
+}
+
+// used for the second step of a login with 2FA
+type UserLoginTwoFactor struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+}
+
+// used for confirming 2FA enrollment and for sensitive actions
+type UserTwoFactorCode struct {
+	Code string `json:"code"`
+}
+
+// used for disabling 2FA
+type UserDisableTwoFactor struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// used for requesting an email change, the new address has to confirm it
+type UserChangeEmail struct {
+	NewEmail string `json:"newEmail"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
 }
+
+// used for confirming an account deletion
+type UserDeleteAccount struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// used for unlocking an account or IP after too many failed logins
+type AdminUnlock struct {
+	Email string `json:"email"`
+	IP    string `json:"ip"`
+}
+
+// used for granting tokens, negative amounts take tokens away
+type AdminAdjustTokens struct {
+	Amount int    `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+// used for switching the model a user's experiments run on
+type AdminSetModelType struct {
+	ModelType string `json:"modelType"`
+}
+
+// used for disabling an account
+type AdminDisableUser struct {
+	Reason string `json:"reason"`
+}
+
+// used for granting or revoking the platform admin role
+type AdminSetRole struct {
+	Role Role `json:"role"`
+}
+
+var ErrEmailTaken = errors.New("email is already registered")
+var ErrTwoFactorEnabled = errors.New("two factor authentication is already enabled")
+var ErrTwoFactorNotEnrolled = errors.New("two factor authentication enrollment wasn't started")
+var ErrInvalidCode = errors.New("invalid two factor code")
+var ErrInsufficientTokens = errors.New("insufficient tokens")
+var ErrUserNotFound = errors.New("user not found")
@@ -0,0 +1,37 @@
+package mongotest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"api.lnlink.net/src/pkg/global"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Setup points the models at the server in MONGO_TEST_URI and a database of the
+// test's own, named after prefix, that is dropped afterwards. skips the test
+// when MONGO_TEST_URI isn't set
+func Setup(t *testing.T, prefix string) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("can't connect to %s: %v", uri, err)
+	}
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	global.MONGO_CLIENT = client
+	global.CONFIG.Mongo.Database = prefix + "_test_" + hex.EncodeToString(buf)
+
+	t.Cleanup(func() {
+		client.Database(global.CONFIG.Mongo.Database).Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+}
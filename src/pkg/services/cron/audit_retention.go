@@ -0,0 +1,42 @@
+package cron
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/lease"
+)
+
+// PurgeAuditEvents drops the audit events older than AUDIT_RETENTION_DAYS
+func PurgeAuditEvents() error {
+	retention := time.Duration(global.CONFIG.Audit.RetentionDays) * 24 * time.Hour
+	deleted, err := audit.Purge(retention)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[AuditRetentionCron] Purged %d audit events older than %d days", deleted, global.CONFIG.Audit.RetentionDays)
+	return nil
+}
+
+// StartAuditRetentionCron starts the cron job that enforces the audit retention
+func StartAuditRetentionCron() {
+	log.Println("[AuditRetentionCron] Starting audit retention cron job")
+	ticker := time.NewTicker(time.Hour)
+	go func() {
+		for range ticker.C {
+			// the purge runs on whichever replica holds the lease
+			_, err := lease.Lead("cron:audit-retention", 2*time.Hour, func(ctx context.Context) {
+				if err := PurgeAuditEvents(); err != nil {
+					log.Printf("[AuditRetentionCron] Error purging audit events: %v", err)
+				}
+			})
+			if err != nil {
+				log.Printf("[AuditRetentionCron] Error acquiring lease: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,38 @@
+package cron
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"api.lnlink.net/src/pkg/models/lease"
+	"api.lnlink.net/src/pkg/services/privacy"
+)
+
+// DeleteExpiredExports removes the data export archives nobody can download anymore
+func DeleteExpiredExports() error {
+	deleted, err := privacy.DeleteExpiredExports()
+	if deleted > 0 {
+		log.Printf("[ExportCleanupCron] Deleted %d expired export archives", deleted)
+	}
+	return err
+}
+
+// StartExportCleanupCron starts the cron job that deletes expired export archives
+func StartExportCleanupCron() {
+	log.Println("[ExportCleanupCron] Starting export cleanup cron job")
+	ticker := time.NewTicker(time.Hour)
+	go func() {
+		for range ticker.C {
+			// the cleanup runs on whichever replica holds the lease
+			_, err := lease.Lead("cron:export-cleanup", 2*time.Hour, func(ctx context.Context) {
+				if err := DeleteExpiredExports(); err != nil {
+					log.Printf("[ExportCleanupCron] Error deleting expired exports: %v", err)
+				}
+			})
+			if err != nil {
+				log.Printf("[ExportCleanupCron] Error acquiring lease: %v", err)
+			}
+		}
+	}()
+}
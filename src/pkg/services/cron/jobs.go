@@ -0,0 +1,42 @@
+package cron
+
+import (
+	"log"
+	"time"
+
+	"api.lnlink.net/src/pkg/models/job"
+	"api.lnlink.net/src/pkg/services/privacy"
+)
+
+// RunPendingJobs works through the queued jobs one at a time
+func RunPendingJobs() error {
+	for {
+		j, err := job.ClaimNext()
+		if err != nil {
+			return err
+		}
+		if j == nil {
+			return nil
+		}
+
+		log.Printf("[JobCron] Running %s job %s for user %s", j.Kind, j.ID.Hex(), j.UserID.Hex())
+		if err := privacy.Run(j); err != nil {
+			log.Printf("[JobCron] Job %s failed: %v", j.ID.Hex(), err)
+			continue
+		}
+		log.Printf("[JobCron] Job %s completed", j.ID.Hex())
+	}
+}
+
+// StartJobCron starts the cron job that runs exports and account deletions
+func StartJobCron() {
+	log.Println("[JobCron] Starting job cron")
+	ticker := time.NewTicker(10 * time.Second)
+	go func() {
+		for range ticker.C {
+			if err := RunPendingJobs(); err != nil {
+				log.Printf("[JobCron] Error running jobs: %v", err)
+			}
+		}
+	}()
+}
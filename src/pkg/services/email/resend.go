@@ -3,19 +3,30 @@ package email
 import (
 	"context"
 	"fmt"
+	"log"
 	"time"
 
-	"api.lnlink.net/src/pkg/errs"
 	"api.lnlink.net/src/pkg/global"
 
 	"github.com/resend/resend-go/v2"
 )
 
 func SendEmail(recipient string, subject string, html string, text string) error {
-	client := resend.NewClient(global.RESEND_API_KEY)
+	// without resend the email goes to the log, the body only when asked for
+	// since its links are as good as the account
+	if !global.CONFIG.Email.Enabled() {
+		if global.CONFIG.Email.LogBodies {
+			log.Printf("[Email] Email is not configured, to %s: %s\n%s", recipient, subject, text)
+		} else {
+			log.Printf("[Email] Email is not configured, not sending %q to %s", subject, recipient)
+		}
+		return nil
+	}
+
+	client := resend.NewClient(global.CONFIG.Email.APIKey)
 
 	params := &resend.SendEmailRequest{
-		From:    global.RESEND_FROM,
+		From:    global.CONFIG.Email.From,
 		To:      []string{recipient},
 		Subject: subject,
 		Html:    html,
@@ -34,7 +45,9 @@ func SendEmail(recipient string, subject string, html string, text string) error
 	for _, sec := range intervals {
 		time.Sleep(sec * time.Second)
 		resp2, err := client.Emails.Get(resp.Id)
-		errs.Invariant(err == nil, "failed to get email status from resend")
+		if err != nil {
+			return fmt.Errorf("failed to get email status from resend: %v", err)
+		}
 		if resp2.LastEvent == "delivered" {
 			return nil
 		}
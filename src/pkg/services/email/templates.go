@@ -0,0 +1,82 @@
+package email
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"time"
+)
+
+// sends an email in the background, SendEmail blocks until delivery
+// so request handlers should never wait on it
+func SendEmailAsync(recipient string, subject string, html string, text string) {
+	go func() {
+		if err := SendEmail(recipient, subject, html, text); err != nil {
+			log.Printf("[Email] Failed to send %q to %s: %v", subject, recipient, err)
+		}
+	}()
+}
+
+// renders a simple email with a paragraph and a call to action link
+func renderLinkEmail(message string, linkText string, link string) (string, string) {
+	htmlBody := fmt.Sprintf(
+		`<p>%s</p><p><a href="%s">%s</a></p><p>If the button doesn't work, copy this link into your browser:<br>%s</p>`,
+		html.EscapeString(message), html.EscapeString(link), html.EscapeString(linkText), html.EscapeString(link),
+	)
+	textBody := fmt.Sprintf("%s\n\n%s: %s\n", message, linkText, link)
+	return htmlBody, textBody
+}
+
+func SendVerificationEmail(recipient string, link string) {
+	htmlBody, textBody := renderLinkEmail(
+		"Welcome to LN Link! Please confirm your email address to activate your account.",
+		"Verify email",
+		link,
+	)
+	SendEmailAsync(recipient, "Verify your LN Link account", htmlBody, textBody)
+}
+
+func SendPasswordResetEmail(recipient string, link string) {
+	htmlBody, textBody := renderLinkEmail(
+		"Someone asked to reset the password for your LN Link account. The link expires in 30 minutes and can only be used once. If this wasn't you, you can ignore this email.",
+		"Reset password",
+		link,
+	)
+	SendEmailAsync(recipient, "Reset your LN Link password", htmlBody, textBody)
+}
+
+func SendAccountLockedEmail(recipient string, until time.Time) {
+	message := fmt.Sprintf(
+		"We saw repeated failed sign in attempts on your LN Link account, so we locked it until %s. If this was you, you can try again after that time or reset your password. If it wasn't, we recommend changing your password.",
+		until.UTC().Format("Jan 2, 2006 15:04 MST"),
+	)
+	htmlBody := fmt.Sprintf("<p>%s</p>", html.EscapeString(message))
+	SendEmailAsync(recipient, "Your LN Link account was temporarily locked", htmlBody, message)
+}
+
+func SendInvitationEmail(recipient string, organizationName string, inviterEmail string, link string) {
+	htmlBody, textBody := renderLinkEmail(
+		fmt.Sprintf("%s invited you to join %s on LN Link. The invitation expires in 7 days.", inviterEmail, organizationName),
+		"Accept invitation",
+		link,
+	)
+	SendEmailAsync(recipient, fmt.Sprintf("Join %s on LN Link", organizationName), htmlBody, textBody)
+}
+
+func SendEmailChangeConfirmation(recipient string, link string) {
+	htmlBody, textBody := renderLinkEmail(
+		"Please confirm that you want to use this address for your LN Link account. The link expires in 24 hours. Until you confirm, your account keeps using its current address.",
+		"Confirm email",
+		link,
+	)
+	SendEmailAsync(recipient, "Confirm your new LN Link email address", htmlBody, textBody)
+}
+
+func SendEmailChangeNotice(recipient string, newEmail string) {
+	message := fmt.Sprintf(
+		"Someone asked to change the email address of your LN Link account to %s. The change only happens once the new address is confirmed. If this wasn't you, change your password right away.",
+		newEmail,
+	)
+	htmlBody := fmt.Sprintf("<p>%s</p>", html.EscapeString(message))
+	SendEmailAsync(recipient, "Your LN Link email address is being changed", htmlBody, message)
+}
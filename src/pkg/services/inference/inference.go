@@ -0,0 +1,79 @@
+package inference
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"api.lnlink.net/src/pkg/config"
+	"api.lnlink.net/src/pkg/errs"
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/services/storage"
+)
+
+// Backend runs the model on one image at a time
+type Backend interface {
+	// queues the image and returns the id of the job at the backend
+	Submit(ctx context.Context, job Job) (string, error)
+	Status(ctx context.Context, id string) (*Status, error)
+	// cancelling a finished job is not an error
+	Cancel(ctx context.Context, id string) error
+}
+
+// Job is one image to run the model on, inputs and outputs live in storage
+type Job struct {
+	FileID          string
+	FileExtension   string
+	MicronsPerPixel float64
+	// the backend posts {"id", "status"} here once the job finished, optional
+	CallbackURL string
+}
+
+// the uploaded image in the input bucket
+func (j Job) InputKey() string {
+	return fmt.Sprintf("innocent/%s%s", j.FileID, j.FileExtension)
+}
+
+// outputs in the output bucket are named after the file id, e.g. <prefix>_0.png
+func (j Job) OutputPrefix() string {
+	return fmt.Sprintf("innocent/%s", j.FileID)
+}
+
+type State string
+
+// the same names RunPod uses, backends map their own states onto these
+const (
+	StateQueued    State = "IN_QUEUE"
+	StateRunning   State = "IN_PROGRESS"
+	StateCompleted State = "COMPLETED"
+	StateFailed    State = "FAILED"
+	StateCancelled State = "CANCELLED"
+)
+
+type Status struct {
+	ID    string
+	State State
+	// how long the model ran, set once the job completed
+	ExecutionTime time.Duration
+	// why the job failed, if the backend says
+	Error string
+}
+
+var backend Backend
+
+// sets up the configured backend, call after storage.Init
+func Init() {
+	switch global.CONFIG.Inference.Backend {
+	case config.InferenceRunPod:
+		backend = NewRunPod(global.CONFIG.RunPod, global.CONFIG.Storage.InputBucket, global.CONFIG.Storage.OutputBucket)
+	case config.InferenceLocal:
+		local, err := NewLocal(global.CONFIG.Inference, storage.Client(), global.CONFIG.Storage.InputBucket, global.CONFIG.Storage.OutputBucket)
+		errs.Invariant(err == nil, "can't configure local inference: %v", err)
+		backend = local
+	}
+}
+
+// the configured backend, nil when inference is disabled
+func Client() Backend {
+	return backend
+}
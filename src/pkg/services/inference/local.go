@@ -0,0 +1,330 @@
+package inference
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"api.lnlink.net/src/pkg/config"
+	"api.lnlink.net/src/pkg/services/storage"
+	"github.com/google/uuid"
+)
+
+// finished jobs are forgotten after this, by then the cron picked up their result
+var LOCAL_JOB_RETENTION = 24 * time.Hour
+
+// Local runs jobs one at a time inside the API process, for development.
+//
+// with a command, the image is downloaded to a temporary directory and the
+// command runs with INPUT_FILE, OUTPUT_DIR, FILE_ID and MICRONS_PER_PIXEL set.
+// every file it leaves in OUTPUT_DIR is uploaded to the output bucket under
+// innocent/, so it should name them like the real worker, e.g. $FILE_ID_0.png,
+// $FILE_ID.json and $FILE_ID_0.xlsx. without a command, placeholder results
+// are written after a delay.
+//
+// jobs only live in memory, after a restart their status reports them as failed
+// so they are retried
+type Local struct {
+	command      []string
+	fakeDuration time.Duration
+	store        storage.Storage
+	inputBucket  string
+	outputBucket string
+
+	mu    sync.Mutex
+	jobs  map[string]*localJob
+	slots chan struct{}
+}
+
+type localJob struct {
+	job        Job
+	state      State
+	err        string
+	startedAt  time.Time
+	finishedAt time.Time
+	cancel     context.CancelFunc
+}
+
+func NewLocal(cfg config.InferenceConfig, store storage.Storage, inputBucket string, outputBucket string) (*Local, error) {
+	if store == nil {
+		return nil, fmt.Errorf("storage is not configured")
+	}
+
+	command := strings.Fields(cfg.LocalCommand)
+	if len(command) > 0 {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			return nil, fmt.Errorf("can't find worker command: %v", err)
+		}
+	}
+
+	return &Local{
+		command:      command,
+		fakeDuration: time.Duration(cfg.FakeSeconds) * time.Second,
+		store:        store,
+		inputBucket:  inputBucket,
+		outputBucket: outputBucket,
+		jobs:         map[string]*localJob{},
+		slots:        make(chan struct{}, 1),
+	}, nil
+}
+
+func (l *Local) Submit(ctx context.Context, job Job) (string, error) {
+	id := uuid.New().String()
+	jobCtx, cancel := context.WithCancel(context.Background())
+
+	l.mu.Lock()
+	l.forgetFinished()
+	l.jobs[id] = &localJob{job: job, state: StateQueued, cancel: cancel}
+	l.mu.Unlock()
+
+	go l.run(jobCtx, id, job)
+	return id, nil
+}
+
+func (l *Local) Status(ctx context.Context, id string) (*Status, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.jobs[id]
+	if !ok {
+		return &Status{ID: id, State: StateFailed, Error: "the local worker doesn't know this job, it was probably restarted"}, nil
+	}
+
+	status := &Status{ID: id, State: current.state, Error: current.err}
+	if current.state == StateCompleted {
+		status.ExecutionTime = current.finishedAt.Sub(current.startedAt)
+	}
+	return status, nil
+}
+
+func (l *Local) Cancel(ctx context.Context, id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.jobs[id]
+	if !ok || (current.state != StateQueued && current.state != StateRunning) {
+		return nil
+	}
+
+	current.cancel()
+	current.state = StateCancelled
+	current.finishedAt = time.Now()
+	return nil
+}
+
+// call with the lock held
+func (l *Local) forgetFinished() {
+	for id, current := range l.jobs {
+		if !current.finishedAt.IsZero() && time.Since(current.finishedAt) > LOCAL_JOB_RETENTION {
+			delete(l.jobs, id)
+		}
+	}
+}
+
+// moves a job on unless it was cancelled in the meantime
+func (l *Local) transition(id string, state State, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current := l.jobs[id]
+	if current == nil || current.state == StateCancelled {
+		return
+	}
+
+	current.state = state
+	switch state {
+	case StateRunning:
+		current.startedAt = time.Now()
+	case StateCompleted, StateFailed:
+		current.finishedAt = time.Now()
+		current.cancel()
+	}
+	if err != nil {
+		current.err = err.Error()
+	}
+}
+
+func (l *Local) run(ctx context.Context, id string, job Job) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	defer func() { <-l.slots }()
+
+	l.transition(id, StateRunning, nil)
+
+	var err error
+	if len(l.command) > 0 {
+		err = l.runCommand(ctx, job)
+	} else {
+		err = l.runFake(ctx, job)
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("[LocalInference] Job %s for file %s failed: %v", id, job.FileID, err)
+		l.transition(id, StateFailed, err)
+	} else {
+		l.transition(id, StateCompleted, nil)
+	}
+
+	if job.CallbackURL != "" {
+		l.callback(job.CallbackURL, id)
+	}
+}
+
+// tells the API the job finished, like RunPod does with its webhook
+func (l *Local) callback(callbackURL string, id string) {
+	status, _ := l.Status(context.Background(), id)
+	body, err := json.Marshal(map[string]any{"id": id, "status": status.State})
+	if err != nil {
+		return
+	}
+
+	resp, err := http.Post(callbackURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[LocalInference] Callback for job %s failed: %v", id, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[LocalInference] Callback for job %s was answered with %d", id, resp.StatusCode)
+	}
+}
+
+// writes blank masks and a results file, enough to exercise downloads
+func (l *Local) runFake(ctx context.Context, job Job) error {
+	select {
+	case <-time.After(l.fakeDuration):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	input, err := l.store.Stream(ctx, l.inputBucket, job.InputKey())
+	if err != nil {
+		return fmt.Errorf("can't read input image: %v", err)
+	}
+	input.Close()
+
+	// an all black mask, nothing was segmented
+	mask := image.NewGray(image.Rect(0, 0, 64, 64))
+	var maskData bytes.Buffer
+	if err := png.Encode(&maskData, mask); err != nil {
+		return err
+	}
+	for i := 0; i <= 1; i++ {
+		key := fmt.Sprintf("%s_%d.png", job.OutputPrefix(), i)
+		if err := l.store.Put(ctx, l.outputBucket, key, bytes.NewReader(maskData.Bytes()), "image/png"); err != nil {
+			return err
+		}
+	}
+
+	results, err := json.MarshalIndent(map[string]any{
+		"fileId":          job.FileID,
+		"micronsPerPixel": job.MicronsPerPixel,
+		"cells":           0,
+		"fake":            true,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return l.store.Put(ctx, l.outputBucket, job.OutputPrefix()+".json", bytes.NewReader(results), "application/json")
+}
+
+func (l *Local) runCommand(ctx context.Context, job Job) error {
+	workDir, err := os.MkdirTemp("", "inference-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	inputFile := filepath.Join(workDir, "input"+job.FileExtension)
+	if err := l.download(ctx, job.InputKey(), inputFile); err != nil {
+		return err
+	}
+	outputDir := filepath.Join(workDir, "output")
+	if err := os.Mkdir(outputDir, 0o755); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, l.command[0], l.command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"INPUT_FILE="+inputFile,
+		"OUTPUT_DIR="+outputDir,
+		"FILE_ID="+job.FileID,
+		fmt.Sprintf("MICRONS_PER_PIXEL=%g", job.MicronsPerPixel),
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("worker command failed: %v: %s", err, lastLines(output))
+	}
+
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err := l.upload(ctx, filepath.Join(outputDir, entry.Name()), "innocent/"+entry.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Local) download(ctx context.Context, key string, target string) error {
+	body, err := l.store.Stream(ctx, l.inputBucket, key)
+	if err != nil {
+		return fmt.Errorf("can't read input image: %v", err)
+	}
+	defer body.Close()
+
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (l *Local) upload(ctx context.Context, source string, key string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return l.store.Put(ctx, l.outputBucket, key, file, mime.TypeByExtension(filepath.Ext(source)))
+}
+
+// keeps error messages short, worker output can be long
+func lastLines(output []byte) string {
+	text := strings.TrimSpace(string(output))
+	if len(text) > 500 {
+		text = "..." + text[len(text)-500:]
+	}
+	if text == "" {
+		return "no output"
+	}
+	return text
+}
@@ -0,0 +1,141 @@
+package inference
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"api.lnlink.net/src/pkg/config"
+)
+
+// RunPod talks to a serverless endpoint, its workers read and write S3 directly
+type RunPod struct {
+	apiKey       string
+	endpoint     string
+	inputBucket  string
+	outputBucket string
+	client       *http.Client
+}
+
+func NewRunPod(cfg config.RunPodConfig, inputBucket string, outputBucket string) *RunPod {
+	return &RunPod{
+		apiKey:       cfg.APIKey,
+		endpoint:     fmt.Sprintf("%s/%s", strings.TrimRight(cfg.BaseURL, "/"), cfg.EndpointID),
+		inputBucket:  inputBucket,
+		outputBucket: outputBucket,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// what the innocent worker expects as input
+type runpodInput struct {
+	S3InputBucketName    string  `json:"s3_input_bucket_name"`
+	S3InputFilePath      string  `json:"s3_input_file_path"`
+	S3OutputBucketName   string  `json:"s3_output_bucket_name"`
+	S3OutputMaskFilePath string  `json:"s3_output_mask_file_path"`
+	S3OutputResultsPath  string  `json:"s3_output_results_file_path"`
+	S3OutputTablePath    string  `json:"s3_output_table_file_path"`
+	NRays                int     `json:"n_rays"`
+	MicronsPerPixel      float64 `json:"microns_per_pixel"`
+}
+
+type runpodResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// in milliseconds
+	ExecutionTime int64  `json:"executionTime"`
+	Error         string `json:"error"`
+}
+
+func (r *RunPod) do(ctx context.Context, method string, path string, body any) (*runpodResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, r.endpoint+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+r.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("runpod responded %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	var response runpodResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (r *RunPod) Submit(ctx context.Context, job Job) (string, error) {
+	prefix := job.OutputPrefix()
+	input := runpodInput{
+		S3InputBucketName:    r.inputBucket,
+		S3InputFilePath:      job.InputKey(),
+		S3OutputBucketName:   r.outputBucket,
+		S3OutputMaskFilePath: prefix + ".png",
+		S3OutputResultsPath:  prefix + ".json",
+		S3OutputTablePath:    prefix + ".xlsx",
+		NRays:                32,
+		MicronsPerPixel:      job.MicronsPerPixel,
+	}
+
+	body := map[string]any{"input": input}
+	if job.CallbackURL != "" {
+		body["webhook"] = job.CallbackURL
+	}
+
+	response, err := r.do(ctx, http.MethodPost, "/run", body)
+	if err != nil {
+		return "", err
+	}
+	if response.ID == "" {
+		return "", fmt.Errorf("runpod didn't return a job id")
+	}
+	return response.ID, nil
+}
+
+func (r *RunPod) Status(ctx context.Context, id string) (*Status, error) {
+	response, err := r.do(ctx, http.MethodGet, "/status/"+id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{ID: response.ID, State: State(response.Status), Error: response.Error}
+	switch response.Status {
+	case "COMPLETED":
+		status.ExecutionTime = time.Duration(response.ExecutionTime) * time.Millisecond
+	case "TIMED_OUT":
+		status.State = StateFailed
+		status.Error = "timed out at runpod"
+	}
+	return status, nil
+}
+
+func (r *RunPod) Cancel(ctx context.Context, id string) error {
+	_, err := r.do(ctx, http.MethodPost, "/cancel/"+id, nil)
+	return err
+}
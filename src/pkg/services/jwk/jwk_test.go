@@ -0,0 +1,143 @@
+package jwk
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+func TestFromPublicKeyRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		publicKey interface {
+			Equal(x crypto.PublicKey) bool
+		}
+		kty string
+		alg string
+	}{
+		{"rsa", &rsaKey.PublicKey, "RSA", "RS256"},
+		{"ed25519", edPublic, "OKP", "EdDSA"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := FromPublicKey(test.publicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Kty != test.kty || key.Alg != test.alg || key.Use != "sig" {
+				t.Fatalf("key = %+v, want kty %s alg %s use sig", key, test.kty, test.alg)
+			}
+			if key.Kid != key.Thumbprint() {
+				t.Fatalf("kid %s isn't the thumbprint %s", key.Kid, key.Thumbprint())
+			}
+
+			// what a relying party decodes from the published set is the key we started with
+			raw, err := json.Marshal(Set{Keys: []Key{key}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var set Set
+			if err := json.Unmarshal(raw, &set); err != nil {
+				t.Fatal(err)
+			}
+			found := set.Find(key.Kid)
+			if found == nil {
+				t.Fatal("key not found by its kid")
+			}
+			publicKey, err := found.PublicKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.publicKey.Equal(publicKey) {
+				t.Fatal("decoded key differs from the original")
+			}
+		})
+	}
+}
+
+func TestFromPublicKeyUnsupported(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FromPublicKey(&ecKey.PublicKey); err == nil {
+		t.Fatal("expected an error for an EC key")
+	}
+}
+
+func TestECPublicKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+		Y:   base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+	}
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecKey.PublicKey.Equal(publicKey) {
+		t.Fatal("decoded key differs from the original")
+	}
+}
+
+// RFC 7638 hashes the required members only, sorted, without whitespace,
+// which is what encoding/json produces for a map
+func TestThumbprint(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       Key
+		canonical map[string]string
+	}{
+		{
+			"rsa",
+			Key{Kty: "RSA", N: "0vx7agoebGcQSuu", E: "AQAB", Alg: "RS256", Use: "sig", Kid: "ignored"},
+			map[string]string{"e": "AQAB", "kty": "RSA", "n": "0vx7agoebGcQSuu"},
+		},
+		{
+			"ec",
+			Key{Kty: "EC", Crv: "P-256", X: "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU", Y: "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"},
+			map[string]string{"crv": "P-256", "kty": "EC", "x": "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU", "y": "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"},
+		},
+		{
+			"okp",
+			Key{Kty: "OKP", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo", Alg: "EdDSA"},
+			map[string]string{"crv": "Ed25519", "kty": "OKP", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			canonical, err := json.Marshal(test.canonical)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(canonical)
+			want := base64.RawURLEncoding.EncodeToString(sum[:])
+			if got := test.key.Thumbprint(); got != want {
+				t.Fatalf("Thumbprint() = %s, want %s", got, want)
+			}
+		})
+	}
+}
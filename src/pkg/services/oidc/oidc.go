@@ -0,0 +1,258 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"api.lnlink.net/src/pkg/services/jwk"
+	jwtv5 "github.com/golang-jwt/jwt/v5"
+)
+
+// the subset of the discovery document we use
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+}
+
+// the ID token claims we care about
+type IDTokenClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Nonce         string
+}
+
+// discovery documents and key sets change rarely, so we cache them
+var CACHE_TTL = time.Hour
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+type cachedDiscovery struct {
+	discovery Discovery
+	fetchedAt time.Time
+}
+
+type cachedKeys struct {
+	keys      jwk.Set
+	fetchedAt time.Time
+}
+
+var cacheMutex sync.Mutex
+var discoveryCache = map[string]cachedDiscovery{}
+var keysCache = map[string]cachedKeys{}
+
+func getJSON(endpoint string, out interface{}) error {
+	resp, err := httpClient.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", endpoint, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// fetches the provider's .well-known/openid-configuration
+func Discover(issuer string) (*Discovery, error) {
+	cacheMutex.Lock()
+	cached, ok := discoveryCache[issuer]
+	cacheMutex.Unlock()
+	if ok && time.Since(cached.fetchedAt) < CACHE_TTL {
+		return &cached.discovery, nil
+	}
+
+	var discovery Discovery
+	err := getJSON(strings.TrimRight(issuer, "/")+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover %s: %v", issuer, err)
+	}
+	if discovery.Issuer != issuer {
+		return nil, fmt.Errorf("discovery issuer %s doesn't match %s", discovery.Issuer, issuer)
+	}
+
+	cacheMutex.Lock()
+	discoveryCache[issuer] = cachedDiscovery{discovery: discovery, fetchedAt: time.Now()}
+	cacheMutex.Unlock()
+
+	return &discovery, nil
+}
+
+// looks up a signing key, refetching the set when the kid is unknown
+// so that key rotation at the provider doesn't break logins
+func signingKey(jwksURI string, kid string) (*jwk.Key, error) {
+	cacheMutex.Lock()
+	cached, ok := keysCache[jwksURI]
+	cacheMutex.Unlock()
+	if ok && time.Since(cached.fetchedAt) < CACHE_TTL {
+		if key := cached.keys.Find(kid); key != nil {
+			return key, nil
+		}
+	}
+
+	var keys jwk.Set
+	if err := getJSON(jwksURI, &keys); err != nil {
+		return nil, fmt.Errorf("failed to fetch provider keys: %v", err)
+	}
+
+	cacheMutex.Lock()
+	keysCache[jwksURI] = cachedKeys{keys: keys, fetchedAt: time.Now()}
+	cacheMutex.Unlock()
+
+	key := keys.Find(kid)
+	if key == nil {
+		return nil, fmt.Errorf("provider has no key %s", kid)
+	}
+	return key, nil
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// generates a PKCE code verifier and its S256 challenge
+func NewPKCE() (string, string, error) {
+	verifier, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// generates a random value for the state and nonce parameters
+func NewNonce() (string, error) {
+	return randomString()
+}
+
+// builds the URL the user is redirected to for logging in at the provider
+func AuthorizationURL(discovery *Discovery, clientID string, redirectURI string, scopes []string, state string, nonce string, codeChallenge string, loginHint string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", clientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+	if loginHint != "" {
+		params.Set("login_hint", loginHint)
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// exchanges an authorization code for tokens
+func ExchangeCode(discovery *Discovery, clientID string, clientSecret string, redirectURI string, code string, codeVerifier string) (*TokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", clientID)
+	form.Set("code_verifier", codeVerifier)
+	if clientSecret != "" {
+		form.Set("client_secret", clientSecret)
+	}
+
+	resp, err := httpClient.PostForm(discovery.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, string(body))
+	}
+
+	var tokens TokenResponse
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	return &tokens, nil
+}
+
+// checks the ID token signature against the provider keys and validates
+// the issuer, audience, expiry and nonce
+func VerifyIDToken(discovery *Discovery, clientID string, rawIDToken string, nonce string) (*IDTokenClaims, error) {
+	parsed, err := jwtv5.Parse(rawIDToken, func(token *jwtv5.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := signingKey(discovery.JWKSURI, kid)
+		if err != nil {
+			return nil, err
+		}
+		return key.PublicKey()
+	},
+		jwtv5.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "EdDSA"}),
+		jwtv5.WithIssuer(discovery.Issuer),
+		jwtv5.WithAudience(clientID),
+		jwtv5.WithExpirationRequired(),
+		jwtv5.WithIssuedAt(),
+		jwtv5.WithLeeway(time.Minute),
+	)
+	if err != nil || !parsed.Valid {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+
+	claims, ok := parsed.Claims.(jwtv5.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid id token claims")
+	}
+
+	result := IDTokenClaims{}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.Nonce, _ = claims["nonce"].(string)
+
+	// some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		result.EmailVerified = verified == "true"
+	}
+
+	if result.Subject == "" {
+		return nil, fmt.Errorf("id token has no subject")
+	}
+	if result.Nonce != nonce {
+		return nil, fmt.Errorf("id token nonce mismatch")
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,167 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api.lnlink.net/src/pkg/services/jwk"
+	jwtv5 "github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "client-123"
+
+// a provider with a generated key, serving its key set from a local server
+type testProvider struct {
+	key       *rsa.PrivateKey
+	kid       string
+	discovery *Discovery
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := jwk.FromPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwk.Set{Keys: []jwk.Key{public}})
+	}))
+	t.Cleanup(server.Close)
+
+	return &testProvider{
+		key: key,
+		kid: public.Kid,
+		discovery: &Discovery{
+			Issuer:  "https://idp.example.com",
+			JWKSURI: server.URL + "/jwks",
+		},
+	}
+}
+
+func (p *testProvider) claims() jwtv5.MapClaims {
+	now := time.Now()
+	return jwtv5.MapClaims{
+		"iss":            p.discovery.Issuer,
+		"aud":            testClientID,
+		"sub":            "subject-1",
+		"email":          "someone@example.com",
+		"email_verified": true,
+		"nonce":          "nonce-1",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+}
+
+func (p *testProvider) sign(t *testing.T, key *rsa.PrivateKey, kid string, claims jwtv5.MapClaims) string {
+	token := jwtv5.NewWithClaims(jwtv5.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	provider := newTestProvider(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(claims jwtv5.MapClaims)
+		key     *rsa.PrivateKey
+		kid     string
+		nonce   string
+		wantErr string
+	}{
+		{name: "valid", nonce: "nonce-1"},
+		{name: "audience list", nonce: "nonce-1", modify: func(c jwtv5.MapClaims) { c["aud"] = []string{"other", testClientID} }},
+		{name: "email verified as string", nonce: "nonce-1", modify: func(c jwtv5.MapClaims) { c["email_verified"] = "true" }},
+		{name: "expired within leeway", nonce: "nonce-1", modify: func(c jwtv5.MapClaims) { c["exp"] = time.Now().Add(-30 * time.Second).Unix() }},
+		{name: "signed by another key", nonce: "nonce-1", key: otherKey, wantErr: "invalid id token"},
+		{name: "unknown kid", nonce: "nonce-1", kid: "unknown", wantErr: "no key unknown"},
+		{name: "wrong nonce", nonce: "nonce-2", wantErr: "nonce mismatch"},
+		{name: "missing nonce", nonce: "nonce-1", modify: func(c jwtv5.MapClaims) { delete(c, "nonce") }, wantErr: "nonce mismatch"},
+		{name: "wrong audience", nonce: "nonce-1", modify: func(c jwtv5.MapClaims) { c["aud"] = "other" }, wantErr: "invalid id token"},
+		{name: "wrong issuer", nonce: "nonce-1", modify: func(c jwtv5.MapClaims) { c["iss"] = "https://evil.example.com" }, wantErr: "invalid id token"},
+		{name: "expired", nonce: "nonce-1", modify: func(c jwtv5.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: "invalid id token"},
+		{name: "no expiry", nonce: "nonce-1", modify: func(c jwtv5.MapClaims) { delete(c, "exp") }, wantErr: "invalid id token"},
+		{name: "issued in the future", nonce: "nonce-1", modify: func(c jwtv5.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }, wantErr: "invalid id token"},
+		{name: "no subject", nonce: "nonce-1", modify: func(c jwtv5.MapClaims) { delete(c, "sub") }, wantErr: "no subject"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := provider.claims()
+			if test.modify != nil {
+				test.modify(claims)
+			}
+			key := provider.key
+			if test.key != nil {
+				key = test.key
+			}
+			kid := provider.kid
+			if test.kid != "" {
+				kid = test.kid
+			}
+
+			result, err := VerifyIDToken(provider.discovery, testClientID, provider.sign(t, key, kid, claims), test.nonce)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Subject != "subject-1" || result.Email != "someone@example.com" || !result.EmailVerified || result.Nonce != "nonce-1" {
+				t.Fatalf("claims = %+v", result)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsUnsignedTokens(t *testing.T) {
+	provider := newTestProvider(t)
+
+	token := jwtv5.NewWithClaims(jwtv5.SigningMethodNone, provider.claims())
+	token.Header["kid"] = provider.kid
+	unsigned, err := token.SignedString(jwtv5.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := VerifyIDToken(provider.discovery, testClientID, unsigned, "nonce-1"); err == nil {
+		t.Fatal("accepted an unsigned token")
+	}
+}
+
+// an HS256 token keyed with the public key must not pass as RS256
+func TestVerifyIDTokenRejectsAlgorithmConfusion(t *testing.T) {
+	provider := newTestProvider(t)
+
+	token := jwtv5.NewWithClaims(jwtv5.SigningMethodHS256, provider.claims())
+	token.Header["kid"] = provider.kid
+	signed, err := token.SignedString(provider.key.PublicKey.N.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := VerifyIDToken(provider.discovery, testClientID, signed, "nonce-1"); err == nil {
+		t.Fatal("accepted an HS256 token")
+	}
+}
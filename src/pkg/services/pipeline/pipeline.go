@@ -0,0 +1,608 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/audit"
+	"api.lnlink.net/src/pkg/models/experiments"
+	"api.lnlink.net/src/pkg/models/lease"
+	"api.lnlink.net/src/pkg/models/organization"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/inference"
+	"api.lnlink.net/src/pkg/services/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const MaxRetries = 3
+
+// how long an image stays claimed by a replica that stopped renewing its claim
+var CLAIM_TTL = 2 * time.Minute
+
+var ErrUnknownExperiment = errors.New("unknown experiment")
+var ErrClaimed = errors.New("experiment is being handled by another worker")
+var ErrInsufficientTokens = errors.New("insufficient tokens")
+
+// what backends post to the callback URL, only the id is trusted,
+// the status is fetched from the backend again
+type Callback struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// how long a job may wait in the backend's queue and then run before it is
+// cancelled and retried
+type timeLimits struct {
+	Queue time.Duration
+	Run   time.Duration
+}
+
+// timeLimitsFor returns the limits of a model type, groups from before the model
+// type was recorded get the default ones
+func timeLimitsFor(modelType string) timeLimits {
+	if modelType == "innocent" {
+		// cold starts of the innocent workers can take a while
+		return timeLimits{Queue: 30 * time.Minute, Run: 15 * time.Minute}
+	}
+	return timeLimits{Queue: 60 * time.Minute, Run: 30 * time.Minute}
+}
+
+// tokensPerImage is what one completed image costs for a model type
+func tokensPerImage(modelType string) int {
+	if modelType == "innocent" {
+		return 16 // 16 tokens per image for innocent model
+	}
+	return 8 // Default 8 tokens per image
+}
+
+func recordTokensDeducted(multiExp *experiments.MultiExperiment, exp experiments.Experiment, tokens int) {
+	audit.Record(audit.Event{
+		Action:         audit.ActionTokensDeducted,
+		UserID:         &multiExp.UserID,
+		OrganizationID: multiExp.OrganizationID,
+		Details:        map[string]any{"experimentId": multiExp.ID, "fileId": exp.FileID, "tokens": tokens},
+	})
+}
+
+func recordExperimentFailed(multiExp *experiments.MultiExperiment, exp experiments.Experiment) {
+	audit.Record(audit.Event{
+		Action:         audit.ActionExperimentFailed,
+		UserID:         &multiExp.UserID,
+		OrganizationID: multiExp.OrganizationID,
+		Details:        map[string]any{"experimentId": multiExp.ID, "fileId": exp.FileID, "retries": exp.RetryCount, "reason": exp.FailureReason},
+	})
+}
+
+func callbackSignature(groupID primitive.ObjectID, fileID string) string {
+	mac := hmac.New(sha256.New, []byte(global.CONFIG.Inference.CallbackSecret))
+	fmt.Fprintf(mac, "%s\n%s", groupID.Hex(), fileID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CallbackURL is where the backend reports an image as finished, empty when callbacks are off
+func CallbackURL(groupID primitive.ObjectID, fileID string) string {
+	if !global.CONFIG.Inference.CallbacksEnabled() {
+		return ""
+	}
+
+	query := url.Values{}
+	query.Set("group", groupID.Hex())
+	query.Set("file", fileID)
+	query.Set("signature", callbackSignature(groupID, fileID))
+	return fmt.Sprintf("%s/api/webhooks/inference?%s", strings.TrimRight(global.CONFIG.URLs.API, "/"), query.Encode())
+}
+
+func VerifyCallback(groupID primitive.ObjectID, fileID string, signature string) bool {
+	if !global.CONFIG.Inference.CallbacksEnabled() {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(callbackSignature(groupID, fileID)))
+}
+
+// Submit sends an image of the group to the inference backend and returns the job id
+func Submit(ctx context.Context, groupID primitive.ObjectID, exp experiments.Experiment) (string, error) {
+	job := exp.InferenceJob()
+	job.CallbackURL = CallbackURL(groupID, exp.FileID)
+	return inference.Client().Submit(ctx, job)
+}
+
+// withClaim runs fn on a fresh copy of the image while no other replica can
+// work on it. returns ErrClaimed when another one already is
+func withClaim(groupID primitive.ObjectID, fileID string, fn func(ctx context.Context, multiExp *experiments.MultiExperiment, i int) error) error {
+	result := ErrUnknownExperiment
+	held, err := lease.Hold(fmt.Sprintf("experiment:%s:%s", groupID.Hex(), fileID), CLAIM_TTL, func(ctx context.Context) {
+		// read after claiming, whatever was read before may be outdated
+		multiExp, err := experiments.GetExperimentByID(groupID)
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			result = err
+			return
+		}
+
+		for i := range multiExp.Experiments {
+			if multiExp.Experiments[i].FileID == fileID {
+				result = fn(ctx, multiExp, i)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !held {
+		return ErrClaimed
+	}
+	return result
+}
+
+// HandleCallback applies the result of a job the backend reported as finished
+func HandleCallback(groupID primitive.ObjectID, fileID string, jobID string) error {
+	return withClaim(groupID, fileID, func(ctx context.Context, multiExp *experiments.MultiExperiment, i int) error {
+		// callbacks of jobs that were retried or already handled change nothing
+		exp := multiExp.Experiments[i]
+		if exp.JobID != jobID || exp.Status != experiments.ExperimentInProgress {
+			return nil
+		}
+
+		status, err := inference.Client().Status(ctx, jobID)
+		if err != nil {
+			return fmt.Errorf("can't get status of job %s: %v", jobID, err)
+		}
+
+		Apply(multiExp, i, status)
+		Finish(multiExp)
+		return nil
+	})
+}
+
+// Reconcile checks an in-progress image at the backend, for results whose
+// callback never arrived. images another replica is working on are skipped
+func Reconcile(groupID primitive.ObjectID, fileID string) error {
+	err := withClaim(groupID, fileID, func(ctx context.Context, multiExp *experiments.MultiExperiment, i int) error {
+		exp := multiExp.Experiments[i]
+		if exp.Status != experiments.ExperimentInProgress {
+			return nil
+		}
+
+		status, err := inference.Client().Status(ctx, exp.JobID)
+		if err != nil {
+			return fmt.Errorf("can't get status of job %s: %v", exp.JobID, err)
+		}
+
+		log.Printf("[Pipeline] Status for experiment %s (job ID: %s) in group %s: %s", exp.FileID, exp.JobID, multiExp.ID, status.State)
+		Apply(multiExp, i, status)
+		return nil
+	})
+	if err == ErrClaimed || err == ErrUnknownExperiment {
+		return nil
+	}
+	return err
+}
+
+// Requeue resubmits a failed image with a fresh retry budget. false means the
+// image is not failed (anymore)
+func Requeue(groupID primitive.ObjectID, fileID string) (bool, error) {
+	requeued := false
+	err := withClaim(groupID, fileID, func(ctx context.Context, multiExp *experiments.MultiExperiment, i int) error {
+		exp := multiExp.Experiments[i]
+		if exp.Status != experiments.ExperimentFailed {
+			return nil
+		}
+
+		jobID, err := Submit(ctx, groupID, exp)
+		if err != nil {
+			return fmt.Errorf("can't resubmit %s: %v", fileID, err)
+		}
+
+		next := exp.Submitted(jobID)
+		next.RetryCount = 0
+		requeued, err = multiExp.Transition(exp, next)
+		if err != nil || !requeued {
+			inference.Client().Cancel(ctx, jobID)
+			return err
+		}
+
+		// the old link misses this image's results
+		return multiExp.SetDownloadURL("")
+	})
+	return requeued, err
+}
+
+// Rerun resubmits a failed or completed image from its stored input, with a new
+// micronsPerPixel unless it is nil. the outputs of the run it replaces are kept
+// under versioned keys. a rerun is charged like a new image once it completes,
+// also when the image was charged before, and nothing when it fails or is
+// cancelled. false means the image is in progress or cancelled
+func Rerun(groupID primitive.ObjectID, fileID string, micronsPerPixel *float64, requestedBy primitive.ObjectID) (bool, error) {
+	rerun := false
+	err := withClaim(groupID, fileID, func(ctx context.Context, multiExp *experiments.MultiExperiment, i int) error {
+		exp := multiExp.Experiments[i]
+		if exp.Status != experiments.ExperimentFailed && exp.Status != experiments.ExperimentCompleted {
+			return nil
+		}
+
+		previous := experiments.Rerun{
+			RequestedAt:     time.Now(),
+			RequestedBy:     requestedBy,
+			JobID:           exp.JobID,
+			Status:          exp.Status,
+			FailureReason:   exp.FailureReason,
+			MicronsPerPixel: exp.MicronsPerPixel,
+		}
+		// the new run writes to the same keys, so move the old outputs out of the way
+		// first. they are moved back if the rerun doesn't happen after all
+		archived, err := archiveOutputs(ctx, exp, len(exp.Reruns)+1)
+		if err != nil {
+			return fmt.Errorf("can't archive outputs of %s: %v", fileID, err)
+		}
+		if archived != nil {
+			previous.OutputPrefix = archived.prefix
+		}
+
+		next := exp
+		if micronsPerPixel != nil {
+			next.MicronsPerPixel = *micronsPerPixel
+		}
+		jobID, err := Submit(ctx, groupID, next)
+		if err != nil {
+			archived.restore(ctx)
+			return fmt.Errorf("can't resubmit %s: %v", fileID, err)
+		}
+
+		next = next.Submitted(jobID)
+		next.RetryCount = 0
+		next.ExecutionTimeMillis = 0
+		next.Reruns = append(slices.Clone(exp.Reruns), previous)
+		rerun, err = multiExp.Transition(exp, next)
+		if err != nil || !rerun {
+			inference.Client().Cancel(ctx, jobID)
+			archived.restore(ctx)
+			return err
+		}
+
+		log.Printf("[Pipeline] Rerunning experiment %s in group %s as job %s", fileID, multiExp.ID, jobID)
+		// the old link has the outputs of the replaced run
+		return multiExp.SetDownloadURL("")
+	})
+	return rerun, err
+}
+
+// the outputs of a replaced run, moved from the image's output prefix to a versioned one
+type archive struct {
+	store    storage.Storage
+	bucket   string
+	current  string
+	prefix   string
+	suffixes []string
+}
+
+// moves the outputs of the current run of an image to <output prefix>_v<version>,
+// nil when there were none
+func archiveOutputs(ctx context.Context, exp experiments.Experiment, version int) (*archive, error) {
+	store, err := storage.Active()
+	if store == nil {
+		return nil, err
+	}
+
+	archived := &archive{
+		store:   store,
+		bucket:  global.CONFIG.Storage.OutputBucket,
+		current: exp.InferenceJob().OutputPrefix(),
+	}
+	archived.prefix = fmt.Sprintf("%s_v%d", archived.current, version)
+
+	keys, err := store.List(ctx, archived.bucket, archived.current)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		suffix := strings.TrimPrefix(key, archived.current)
+		// earlier versions share the prefix
+		if !strings.HasPrefix(suffix, "_v") {
+			archived.suffixes = append(archived.suffixes, suffix)
+		}
+	}
+	if len(archived.suffixes) == 0 {
+		return nil, nil
+	}
+
+	if err := archived.move(ctx, archived.current, archived.prefix); err != nil {
+		return nil, err
+	}
+	return archived, nil
+}
+
+// puts the outputs back where they were, for reruns that didn't happen
+func (a *archive) restore(ctx context.Context) {
+	if a == nil {
+		return
+	}
+	if err := a.move(ctx, a.prefix, a.current); err != nil {
+		log.Printf("[Pipeline] Error restoring outputs from %s: %v", a.prefix, err)
+	}
+}
+
+// copies every output before deleting any, so a failure leaves them where they were
+func (a *archive) move(ctx context.Context, from string, to string) error {
+	for i, suffix := range a.suffixes {
+		if err := a.store.Copy(ctx, a.bucket, from+suffix, to+suffix); err != nil {
+			for _, copied := range a.suffixes[:i] {
+				a.store.Delete(ctx, a.bucket, to+copied)
+			}
+			return err
+		}
+	}
+
+	for i, suffix := range a.suffixes {
+		if err := a.store.Delete(ctx, a.bucket, from+suffix); err != nil {
+			// put back the ones that were already deleted and drop the copies
+			for _, deleted := range a.suffixes[:i] {
+				a.store.Copy(ctx, a.bucket, to+deleted, from+deleted)
+			}
+			for _, copied := range a.suffixes {
+				a.store.Delete(ctx, a.bucket, to+copied)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Cancel stops an image that is still in progress. it is marked cancelled before
+// the job is cancelled at the backend, so a result that arrives anyway is
+// ignored and never charged. false means the image already finished
+func Cancel(groupID primitive.ObjectID, fileID string) (bool, error) {
+	cancelled := false
+	err := withClaim(groupID, fileID, func(ctx context.Context, multiExp *experiments.MultiExperiment, i int) error {
+		exp := multiExp.Experiments[i]
+		if exp.Status != experiments.ExperimentInProgress {
+			return nil
+		}
+
+		next := exp
+		next.Status = experiments.ExperimentCancelled
+		var err error
+		cancelled, err = multiExp.Transition(exp, next)
+		if err != nil || !cancelled {
+			return err
+		}
+
+		log.Printf("[Pipeline] Experiment %s (job ID: %s) in group %s cancelled", exp.FileID, exp.JobID, multiExp.ID)
+		if err := inference.Client().Cancel(ctx, exp.JobID); err != nil {
+			log.Printf("[Pipeline] Error cancelling job %s: %v", exp.JobID, err)
+		}
+		return nil
+	})
+	return cancelled, err
+}
+
+// Apply moves an image on according to its status at the backend: completed
+// images are charged, failed ones retried until they used up their retries.
+// callers hold the claim on the image, every change is also a conditional
+// Transition so a stale copy can never apply a result twice. a crash between
+// completing an image and charging it leaves it uncharged rather than charged twice
+func Apply(multiExp *experiments.MultiExperiment, i int, status *inference.Status) {
+	exp := multiExp.Experiments[i]
+
+	switch status.State {
+	case inference.StateCompleted:
+		completed := exp
+		completed.Status = experiments.ExperimentCompleted
+		completed.ExecutionTimeMillis = int(math.Ceil(status.ExecutionTime.Seconds()))
+
+		moved, err := multiExp.Transition(exp, completed)
+		if err != nil {
+			log.Printf("[Pipeline] Error completing experiment %s in group %s: %v", exp.FileID, multiExp.ID, err)
+			return
+		}
+		if !moved {
+			return
+		}
+		log.Printf("[Pipeline] Experiment %s (job ID: %s) in group %s completed", exp.FileID, exp.JobID, multiExp.ID)
+		charge(multiExp, exp)
+	case inference.StateQueued, inference.StateRunning:
+		checkTimeout(multiExp, exp, status)
+	default:
+		log.Printf("[Pipeline] Experiment %s (job ID: %s) in group %s ended as %s: %s", exp.FileID, exp.JobID, multiExp.ID, status.State, status.Error)
+		reason := status.Error
+		if reason == "" {
+			reason = fmt.Sprintf("the job ended as %s", status.State)
+		}
+		retry(multiExp, exp, reason)
+	}
+}
+
+// checkTimeout notes when a job started running, and cancels and retries jobs that
+// waited or ran longer than their model allows
+func checkTimeout(multiExp *experiments.MultiExperiment, exp experiments.Experiment, status *inference.Status) {
+	now := time.Now()
+	if status.State == inference.StateRunning && exp.StartedAt == nil {
+		started := exp
+		started.StartedAt = &now
+		moved, err := multiExp.Transition(exp, started)
+		if err != nil {
+			log.Printf("[Pipeline] Error storing start of experiment %s in group %s: %v", exp.FileID, multiExp.ID, err)
+			return
+		}
+		if !moved {
+			return
+		}
+		exp = started
+	}
+
+	// images submitted before the timestamps were recorded can only time out once running
+	limits := timeLimitsFor(multiExp.ModelType)
+	var reason string
+	switch {
+	case exp.StartedAt != nil && now.Sub(*exp.StartedAt) > limits.Run:
+		reason = fmt.Sprintf("timed out after running for more than %d minutes", int(limits.Run.Minutes()))
+	case exp.StartedAt == nil && exp.SubmittedAt != nil && now.Sub(*exp.SubmittedAt) > limits.Queue:
+		reason = fmt.Sprintf("timed out after waiting in the queue for more than %d minutes", int(limits.Queue.Minutes()))
+	default:
+		return
+	}
+
+	log.Printf("[Pipeline] Experiment %s (job ID: %s) in group %s %s, cancelling", exp.FileID, exp.JobID, multiExp.ID, reason)
+	// a job that finishes anyway is ignored, its id is no longer the image's
+	if err := inference.Client().Cancel(context.Background(), exp.JobID); err != nil {
+		log.Printf("[Pipeline] Error cancelling job %s: %v", exp.JobID, err)
+	}
+	retry(multiExp, exp, reason)
+}
+
+// the organization pays for groups submitted in one, the user for the others
+// and for groups whose organization is gone
+func wallet(multiExp *experiments.MultiExperiment) (*organization.Organization, *user.User) {
+	if multiExp.OrganizationID != nil {
+		if org := organization.GetByID(*multiExp.OrganizationID); org != nil {
+			return org, nil
+		}
+	}
+	return nil, user.GetUserByID(multiExp.UserID)
+}
+
+// the balance of the wallet and what an image costs it, for the model the group was
+// submitted with. groups from before the model was recorded use the wallet's
+func priceFor(multiExp *experiments.MultiExperiment, org *organization.Organization, owner *user.User) (int, int) {
+	modelType := multiExp.ModelType
+	if org != nil {
+		if modelType == "" {
+			modelType = org.ModelType
+		}
+		return org.TokensAvailable, tokensPerImage(modelType)
+	}
+	if modelType == "" {
+		modelType = owner.ModelType
+	}
+	return owner.TokensAvailable, tokensPerImage(modelType)
+}
+
+// CheckBalance is called before images of the group are submitted, its wallet has
+// to cover all of them. tokens aren't reserved, images are charged as they complete
+// and a charge is never skipped, so groups submitted at once can overdraw a wallet
+func CheckBalance(multiExp *experiments.MultiExperiment, images int) error {
+	org, owner := wallet(multiExp)
+	if org == nil && owner == nil {
+		return ErrUnknownExperiment
+	}
+	balance, price := priceFor(multiExp, org, owner)
+	if balance < price*images {
+		return ErrInsufficientTokens
+	}
+	return nil
+}
+
+// charges the group's wallet for a completed image
+func charge(multiExp *experiments.MultiExperiment, exp experiments.Experiment) {
+	org, owner := wallet(multiExp)
+	if org == nil && owner == nil {
+		log.Printf("[Pipeline] Can't charge for experiment %s in group %s, user %s is gone", exp.FileID, multiExp.ID, multiExp.UserID)
+		return
+	}
+
+	_, tokensToDeduct := priceFor(multiExp, org, owner)
+	if org != nil {
+		if err := org.Charge(tokensToDeduct); err != nil {
+			log.Printf("[Pipeline] Error deducting tokens from organization %s: %v", org.ID, err)
+			return
+		}
+		log.Printf("[Pipeline] Deducted %d tokens from organization %s", tokensToDeduct, org.ID)
+	} else {
+		if err := owner.Charge(tokensToDeduct); err != nil {
+			log.Printf("[Pipeline] Error deducting tokens from user %s: %v", owner.ID, err)
+			return
+		}
+		log.Printf("[Pipeline] Deducted %d tokens from user %s", tokensToDeduct, owner.ID)
+	}
+	recordTokensDeducted(multiExp, exp, tokensToDeduct)
+}
+
+// resubmits a failed image until it used up its retries, the reason of the
+// last attempt is kept on the image once it failed for good
+func retry(multiExp *experiments.MultiExperiment, exp experiments.Experiment, reason string) {
+	if exp.RetryCount >= MaxRetries {
+		failed := exp
+		failed.Status = experiments.ExperimentFailed
+		failed.FailureReason = fmt.Sprintf("%s (after %d retries)", reason, MaxRetries)
+		moved, err := multiExp.Transition(exp, failed)
+		if err != nil {
+			log.Printf("[Pipeline] Error failing experiment %s in group %s: %v", exp.FileID, multiExp.ID, err)
+			return
+		}
+		if moved {
+			log.Printf("[Pipeline] Experiment %s in group %s failed: %s", exp.FileID, multiExp.ID, failed.FailureReason)
+			recordExperimentFailed(multiExp, failed)
+		}
+		return
+	}
+
+	log.Printf("[Pipeline] Retrying experiment %s in group %s - attempt %d/%d", exp.FileID, multiExp.ID, exp.RetryCount+1, MaxRetries)
+	retried := exp
+	jobID, err := Submit(context.Background(), multiExp.ID, exp)
+	if err != nil {
+		log.Printf("[Pipeline] Error resubmitting experiment %s: %v", exp.FileID, err)
+		retried.Status = experiments.ExperimentFailed
+		retried.FailureReason = fmt.Sprintf("%s, resubmitting failed: %v", reason, err)
+	} else {
+		retried = exp.Submitted(jobID)
+		retried.RetryCount++
+	}
+
+	moved, err := multiExp.Transition(exp, retried)
+	if err != nil {
+		log.Printf("[Pipeline] Error storing retry of experiment %s in group %s: %v", exp.FileID, multiExp.ID, err)
+	}
+	// someone else handled the failure first, their job is the one that counts
+	if !moved && jobID != "" {
+		if err := inference.Client().Cancel(context.Background(), jobID); err != nil {
+			log.Printf("[Pipeline] Error cancelling duplicate job %s: %v", jobID, err)
+		}
+	}
+}
+
+// Finished reports whether every image of the group completed or was cancelled
+// and at least one completed, only then is there something to download
+func Finished(multiExp *experiments.MultiExperiment) bool {
+	completed := 0
+	for _, exp := range multiExp.Experiments {
+		switch exp.Status {
+		case experiments.ExperimentCompleted:
+			completed++
+		case experiments.ExperimentCancelled:
+		default:
+			return false
+		}
+	}
+	return completed > 0
+}
+
+// Finish generates the download link once the group is finished
+func Finish(multiExp *experiments.MultiExperiment) {
+	if multiExp.DownloadURL != "" || !Finished(multiExp) {
+		return
+	}
+
+	log.Printf("[Pipeline] All experiments completed for group %s, generating download URL", multiExp.ID)
+	downloadURL, err := experiments.GenerateDownloadLink(multiExp.ID)
+	if err != nil {
+		log.Printf("[Pipeline] Error generating download URL for group %s: %v", multiExp.ID, err)
+		return
+	}
+	if err := multiExp.SetDownloadURL(downloadURL); err != nil {
+		log.Printf("[Pipeline] Error storing download URL for group %s: %v", multiExp.ID, err)
+	}
+}
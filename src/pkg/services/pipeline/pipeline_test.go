@@ -0,0 +1,234 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"api.lnlink.net/src/pkg/config"
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/experiments"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/mongotest"
+	"api.lnlink.net/src/pkg/services/inference"
+	"api.lnlink.net/src/pkg/services/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runs the pipeline against local storage in a temporary directory and the local
+// inference backend, which writes placeholder results right away. returns a user
+// with 100 tokens and a group with one submitted image
+func setup(t *testing.T) (*user.User, *experiments.MultiExperiment) {
+	mongotest.Setup(t, "pipeline")
+
+	global.CONFIG.URLs.API = "http://localhost:8080"
+	global.CONFIG.Storage = config.StorageConfig{
+		Backend:      config.StorageLocal,
+		InputBucket:  "input",
+		OutputBucket: "output",
+		LocalDir:     t.TempDir(),
+		SigningKey:   "test",
+	}
+	global.CONFIG.Inference = config.InferenceConfig{Backend: config.InferenceLocal}
+	storage.Init()
+	inference.Init()
+
+	owner := &user.User{ID: primitive.NewObjectID(), Email: "owner@example.com", TokensAvailable: 100, ModelType: "innocent"}
+	users := global.MONGO_CLIENT.Database(global.CONFIG.Mongo.Database).Collection(user.UserCollection)
+	if _, err := users.InsertOne(context.Background(), owner); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := experiments.Experiment{FileID: "image", FileExtension: ".png", MicronsPerPixel: 0.5}
+	job := exp.InferenceJob()
+	if err := storage.Client().Put(context.Background(), "input", job.InputKey(), bytes.NewReader([]byte("image")), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	multiExp := &experiments.MultiExperiment{ID: primitive.NewObjectID(), ModelType: "innocent"}
+	jobID, err := Submit(context.Background(), multiExp.ID, exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiExp.Experiments = []experiments.Experiment{exp.Submitted(jobID)}
+	if err := multiExp.Create(owner.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	return owner, multiExp
+}
+
+// a fresh copy of the group, like a replica would read it
+func load(t *testing.T, groupID primitive.ObjectID) *experiments.MultiExperiment {
+	multiExp, err := experiments.GetExperimentByID(groupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return multiExp
+}
+
+// waits until the local backend finished the job
+func waitForCompletion(t *testing.T, jobID string) *inference.Status {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status, err := inference.Client().Status(context.Background(), jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.State == inference.StateCompleted {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s didn't complete", jobID)
+	return nil
+}
+
+func balance(t *testing.T, userID primitive.ObjectID) int {
+	u := user.GetUserByID(userID)
+	if u == nil {
+		t.Fatalf("user %s is gone", userID)
+	}
+	return u.TokensAvailable
+}
+
+func outputs(t *testing.T, prefix string) []string {
+	keys, err := storage.Client().List(context.Background(), "output", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestCompletionIsChargedOnce(t *testing.T) {
+	owner, multiExp := setup(t)
+	status := waitForCompletion(t, multiExp.Experiments[0].JobID)
+
+	// two replicas that both read the group before either applied the result
+	first, second := load(t, multiExp.ID), load(t, multiExp.ID)
+	Apply(first, 0, status)
+	Apply(second, 0, status)
+	if err := HandleCallback(multiExp.ID, "image", status.ID); err != nil {
+		t.Fatalf("HandleCallback = %v", err)
+	}
+
+	if got := load(t, multiExp.ID).Experiments[0].Status; got != experiments.ExperimentCompleted {
+		t.Fatalf("status = %s, want %s", got, experiments.ExperimentCompleted)
+	}
+	if got := balance(t, owner.ID); got != 100-tokensPerImage("innocent") {
+		t.Fatalf("balance = %d, want %d", got, 100-tokensPerImage("innocent"))
+	}
+}
+
+func TestCancelBeforeCompletionIsNotCharged(t *testing.T) {
+	owner, multiExp := setup(t)
+	status := waitForCompletion(t, multiExp.Experiments[0].JobID)
+
+	// the result was read before the user cancelled and is applied after
+	stale := load(t, multiExp.ID)
+	cancelled, err := Cancel(multiExp.ID, "image")
+	if err != nil || !cancelled {
+		t.Fatalf("Cancel = %v, %v, want true", cancelled, err)
+	}
+	Apply(stale, 0, status)
+	if err := HandleCallback(multiExp.ID, "image", status.ID); err != nil {
+		t.Fatalf("HandleCallback = %v", err)
+	}
+
+	if got := load(t, multiExp.ID).Experiments[0].Status; got != experiments.ExperimentCancelled {
+		t.Fatalf("status = %s, want %s", got, experiments.ExperimentCancelled)
+	}
+	if got := balance(t, owner.ID); got != 100 {
+		t.Fatalf("balance = %d, want 100", got)
+	}
+
+	cancelled, err = Cancel(multiExp.ID, "image")
+	if err != nil || cancelled {
+		t.Fatalf("second Cancel = %v, %v, want false", cancelled, err)
+	}
+}
+
+func TestRetriesRunOut(t *testing.T) {
+	owner, multiExp := setup(t)
+	failed := &inference.Status{State: inference.StateFailed, Error: "out of memory"}
+
+	jobIDs := []string{}
+	for attempt := 0; attempt <= MaxRetries; attempt++ {
+		current := load(t, multiExp.ID)
+		if current.Experiments[0].Status != experiments.ExperimentInProgress {
+			t.Fatalf("attempt %d: status = %s, want it still in progress", attempt, current.Experiments[0].Status)
+		}
+		jobIDs = append(jobIDs, current.Experiments[0].JobID)
+		Apply(current, 0, failed)
+	}
+	// the resubmitted jobs write into the temporary directory until they are done
+	for _, jobID := range jobIDs {
+		waitForCompletion(t, jobID)
+	}
+
+	exp := load(t, multiExp.ID).Experiments[0]
+	if exp.Status != experiments.ExperimentFailed {
+		t.Fatalf("status = %s, want %s", exp.Status, experiments.ExperimentFailed)
+	}
+	if exp.RetryCount != MaxRetries {
+		t.Fatalf("retries = %d, want %d", exp.RetryCount, MaxRetries)
+	}
+	if !strings.HasPrefix(exp.FailureReason, "out of memory") {
+		t.Fatalf("failure reason = %q, want the backend's error", exp.FailureReason)
+	}
+	if got := balance(t, owner.ID); got != 100 {
+		t.Fatalf("balance = %d, want 100", got)
+	}
+}
+
+func TestRerunArchivesOutputs(t *testing.T) {
+	owner, multiExp := setup(t)
+	Apply(load(t, multiExp.ID), 0, waitForCompletion(t, multiExp.Experiments[0].JobID))
+
+	before := outputs(t, "innocent/image")
+	if len(before) == 0 {
+		t.Fatal("the local backend wrote no outputs")
+	}
+
+	rerun, err := Rerun(multiExp.ID, "image", nil, owner.ID)
+	if err != nil || !rerun {
+		t.Fatalf("Rerun = %v, %v, want true", rerun, err)
+	}
+
+	exp := load(t, multiExp.ID).Experiments[0]
+	waitForCompletion(t, exp.JobID)
+	if len(exp.Reruns) != 1 || exp.Reruns[0].OutputPrefix != "innocent/image_v1" {
+		t.Fatalf("reruns = %+v, want one archived under innocent/image_v1", exp.Reruns)
+	}
+	for _, key := range before {
+		archived := "innocent/image_v1" + strings.TrimPrefix(key, "innocent/image")
+		if !slices.Contains(outputs(t, "innocent/image_v1"), archived) {
+			t.Fatalf("%s wasn't archived as %s", key, archived)
+		}
+	}
+}
+
+func TestArchiveRestore(t *testing.T) {
+	_, multiExp := setup(t)
+	exp := multiExp.Experiments[0]
+	Apply(load(t, multiExp.ID), 0, waitForCompletion(t, exp.JobID))
+
+	before := outputs(t, "innocent/image")
+	archived, err := archiveOutputs(context.Background(), exp, 1)
+	if err != nil || archived == nil {
+		t.Fatalf("archiveOutputs = %v, %v", archived, err)
+	}
+	if got := outputs(t, "innocent/image"); !slices.Equal(got, outputs(t, "innocent/image_v1")) {
+		t.Fatalf("after archiving outputs = %v, want only the archived ones", got)
+	}
+
+	// a rerun that didn't happen puts them back
+	archived.restore(context.Background())
+	if got := outputs(t, "innocent/image"); !slices.Equal(got, before) {
+		t.Fatalf("after restoring outputs = %v, want %v", got, before)
+	}
+}
@@ -0,0 +1,143 @@
+package privacy
+
+import (
+	"context"
+	"fmt"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/apikey"
+	"api.lnlink.net/src/pkg/models/experiments"
+	"api.lnlink.net/src/pkg/models/invitation"
+	"api.lnlink.net/src/pkg/models/job"
+	"api.lnlink.net/src/pkg/models/onetime"
+	"api.lnlink.net/src/pkg/models/organization"
+	"api.lnlink.net/src/pkg/models/throttle"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/pipeline"
+	"api.lnlink.net/src/pkg/services/storage"
+	"api.lnlink.net/src/pkg/services/stripe"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// experiments submitted in an organization belong to it and are kept while it
+// has other members, everything else the user submitted goes
+func keepForOrganization(multiExp *experiments.MultiExperiment, userID primitive.ObjectID) (bool, error) {
+	if multiExp.OrganizationID == nil {
+		return false, nil
+	}
+
+	org := organization.GetByID(*multiExp.OrganizationID)
+	if org == nil {
+		return false, nil
+	}
+
+	members, err := org.Members()
+	if err != nil {
+		return false, err
+	}
+	for _, member := range members {
+		if member.ID != userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deletes the user's files, experiments, credentials and billing customer,
+// then anonymizes the user document. every step can be repeated, so a job
+// that died halfway is simply run again
+func DeleteAccount(j *job.Job) error {
+	ctx := context.Background()
+
+	u := user.GetUserByID(j.UserID)
+	if u == nil {
+		return fmt.Errorf("user not found")
+	}
+	if u.DeletedAt != nil {
+		return nil
+	}
+
+	store, err := storage.Active()
+	if err != nil {
+		return err
+	}
+
+	j.ReportProgress(5, "Deleting experiments")
+	multiExperiments, err := experiments.ListSubmittedBy(u.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list experiments: %v", err)
+	}
+
+	for i, multiExp := range multiExperiments {
+		keep, err := keepForOrganization(&multiExp, u.ID)
+		if err != nil {
+			return err
+		}
+
+		if !keep {
+			// images still at the backend would write their results into the
+			// prefixes deleted below, a claimed one fails the job until it is retried
+			for _, exp := range multiExp.Experiments {
+				if exp.Status != experiments.ExperimentInProgress {
+					continue
+				}
+				if _, err := pipeline.Cancel(multiExp.ID, exp.FileID); err != nil {
+					return fmt.Errorf("failed to cancel experiment %s: %v", exp.FileID, err)
+				}
+			}
+			for _, exp := range multiExp.Experiments {
+				if err := deletePrefix(ctx, store, global.CONFIG.Storage.InputBucket, imagePrefix(exp)); err != nil {
+					return err
+				}
+				if err := deletePrefix(ctx, store, global.CONFIG.Storage.OutputBucket, imagePrefix(exp)); err != nil {
+					return err
+				}
+			}
+			if err := deleteKey(ctx, store, global.CONFIG.Storage.OutputBucket, fmt.Sprintf("downloads/%s.zip", multiExp.ID.Hex())); err != nil {
+				return err
+			}
+			if err := multiExp.Delete(); err != nil {
+				return fmt.Errorf("failed to delete experiment %s: %v", multiExp.ID.Hex(), err)
+			}
+		}
+
+		j.ReportProgress(5+70*(i+1)/len(multiExperiments), fmt.Sprintf("Deleted %d/%d experiments", i+1, len(multiExperiments)))
+	}
+
+	j.ReportProgress(80, "Deleting data exports")
+	jobs, err := job.ListForUser(u.ID)
+	if err != nil {
+		return err
+	}
+	for _, other := range jobs {
+		if other.Kind == job.KindDataExport {
+			if err := deleteKey(ctx, store, global.CONFIG.Storage.OutputBucket, exportKey(other.ID.Hex())); err != nil {
+				return err
+			}
+		}
+	}
+
+	j.ReportProgress(85, "Removing billing details")
+	if u.StripeCustomerID != "" {
+		if err := stripe.DeleteCustomer(u.StripeCustomerID); err != nil {
+			return fmt.Errorf("failed to delete stripe customer: %v", err)
+		}
+	}
+
+	j.ReportProgress(90, "Removing credentials")
+	if err := apikey.DeleteAllForUser(u.ID); err != nil {
+		return fmt.Errorf("failed to delete API keys: %v", err)
+	}
+	if err := onetime.DeleteAllForUser(u.ID); err != nil {
+		return fmt.Errorf("failed to delete one time tokens: %v", err)
+	}
+	if err := invitation.DeleteForEmail(u.Email); err != nil {
+		return err
+	}
+	if err := throttle.Reset(throttle.KindAccount, throttle.AccountKey(u.Email)); err != nil {
+		return err
+	}
+
+	j.ReportProgress(95, "Anonymizing account")
+	return u.Anonymize()
+}
@@ -0,0 +1,164 @@
+package privacy
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"api.lnlink.net/src/pkg/global"
+	"api.lnlink.net/src/pkg/models/experiments"
+	"api.lnlink.net/src/pkg/models/job"
+	"api.lnlink.net/src/pkg/models/user"
+	"api.lnlink.net/src/pkg/services/storage"
+	"api.lnlink.net/src/pkg/services/stripe"
+)
+
+// presigned S3 links can't live longer than a week
+var EXPORT_LINK_EXPIRATION = 7 * 24 * time.Hour
+
+// where the archive of an export job is stored in the output bucket
+func exportKey(jobID string) string {
+	return fmt.Sprintf("exports/%s.zip", jobID)
+}
+
+// DeleteExpiredExports removes the archives whose download links expired, returns how many
+func DeleteExpiredExports() (int, error) {
+	jobs, err := job.ListExportsFinishedBefore(time.Now().Add(-EXPORT_LINK_EXPIRATION))
+	if err != nil {
+		return 0, err
+	}
+
+	store, err := storage.Active()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, j := range jobs {
+		if err := deleteKey(context.Background(), store, global.CONFIG.Storage.OutputBucket, exportKey(j.ID.Hex())); err != nil {
+			return i, err
+		}
+		if err := j.ClearDownload(); err != nil {
+			return i, err
+		}
+	}
+	return len(jobs), nil
+}
+
+// the profile as we hand it out, secrets like the password hash and 2FA seed stay out
+func profile(u *user.User) map[string]any {
+	return map[string]any{
+		"id":               u.ID,
+		"email":            u.Email,
+		"sessions":         u.Sessions,
+		"twoFactorEnabled": u.HasTwoFactor(),
+		"ssoIdentities":    u.SSOIdentities,
+		"stripeCustomerID": u.StripeCustomerID,
+		"tokensAvailable":  u.TokensAvailable,
+		"modelType":        u.ModelType,
+		"organizationId":   u.OrganizationID,
+		"organizationRole": u.OrganizationRole,
+		"createdAt":        u.CreatedAt,
+		"updatedAt":        u.UpdatedAt,
+	}
+}
+
+func writeJSON(zipWriter *zip.Writer, name string, value any) error {
+	writer, err := zipWriter.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to archive: %v", name, err)
+	}
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// bundles everything we hold about the user into a zip and returns a link to it
+func Export(j *job.Job) (string, error) {
+	ctx := context.Background()
+
+	u := user.GetUserByID(j.UserID)
+	if u == nil || u.DeletedAt != nil {
+		return "", fmt.Errorf("user not found")
+	}
+
+	j.ReportProgress(5, "Collecting account data")
+	multiExperiments, err := experiments.ListSubmittedBy(u.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list experiments: %v", err)
+	}
+
+	purchases := []stripe.Purchase{}
+	if u.StripeCustomerID != "" {
+		purchases, err = stripe.ListPurchases(u.StripeCustomerID)
+		if err != nil {
+			return "", fmt.Errorf("failed to list purchases: %v", err)
+		}
+	}
+
+	// the archive can get large, so it's built on disk rather than in memory
+	archive, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return "", fmt.Errorf("failed to create archive: %v", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	zipWriter := zip.NewWriter(archive)
+	if err := writeJSON(zipWriter, "profile.json", profile(u)); err != nil {
+		return "", err
+	}
+	if err := writeJSON(zipWriter, "experiments.json", multiExperiments); err != nil {
+		return "", err
+	}
+	if err := writeJSON(zipWriter, "purchases.json", purchases); err != nil {
+		return "", err
+	}
+
+	store, err := storage.Active()
+	if err != nil {
+		return "", err
+	}
+	if store == nil {
+		return "", fmt.Errorf("storage is not configured")
+	}
+
+	images := 0
+	for _, multiExp := range multiExperiments {
+		images += len(multiExp.Experiments)
+	}
+
+	copied := 0
+	for _, multiExp := range multiExperiments {
+		for _, exp := range multiExp.Experiments {
+			dir := fmt.Sprintf("files/%s/%s", multiExp.ID.Hex(), exp.FileID)
+			if err := copyToZip(ctx, store, zipWriter, global.CONFIG.Storage.InputBucket, imagePrefix(exp), dir+"/input"); err != nil {
+				return "", err
+			}
+			if err := copyToZip(ctx, store, zipWriter, global.CONFIG.Storage.OutputBucket, imagePrefix(exp), dir+"/output"); err != nil {
+				return "", err
+			}
+
+			copied++
+			j.ReportProgress(10+80*copied/images, fmt.Sprintf("Copied files of %d/%d images", copied, images))
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to close archive: %v", err)
+	}
+	if _, err := archive.Seek(0, 0); err != nil {
+		return "", fmt.Errorf("failed to rewind archive: %v", err)
+	}
+
+	j.ReportProgress(95, "Uploading archive")
+	key := exportKey(j.ID.Hex())
+	if err := store.Put(ctx, global.CONFIG.Storage.OutputBucket, key, archive, "application/zip"); err != nil {
+		return "", fmt.Errorf("failed to upload archive: %v", err)
+	}
+
+	return store.Presign(ctx, global.CONFIG.Storage.OutputBucket, key, EXPORT_LINK_EXPIRATION)
+}
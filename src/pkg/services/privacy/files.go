@@ -0,0 +1,57 @@
+package privacy
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"api.lnlink.net/src/pkg/models/experiments"
+	"api.lnlink.net/src/pkg/services/storage"
+)
+
+// the input and every output variant of an image share its file id as key prefix
+func imagePrefix(exp experiments.Experiment) string {
+	return fmt.Sprintf("innocent/%s", exp.FileID)
+}
+
+// streams every object under the prefix into the archive directory
+func copyToZip(ctx context.Context, store storage.Storage, zipWriter *zip.Writer, bucket string, prefix string, dir string) error {
+	keys, err := store.List(ctx, bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		body, err := store.Stream(ctx, bucket, key)
+		if err != nil {
+			return err
+		}
+
+		writer, err := zipWriter.Create(path.Join(dir, path.Base(key)))
+		if err == nil {
+			_, err = io.Copy(writer, body)
+		}
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to add %s/%s to archive: %v", bucket, key, err)
+		}
+	}
+	return nil
+}
+
+// without storage nothing can have been stored, so there is nothing to delete
+func deletePrefix(ctx context.Context, store storage.Storage, bucket string, prefix string) error {
+	if store == nil {
+		return nil
+	}
+	return storage.DeletePrefix(ctx, store, bucket, prefix)
+}
+
+func deleteKey(ctx context.Context, store storage.Storage, bucket string, key string) error {
+	if store == nil {
+		return nil
+	}
+	return store.Delete(ctx, bucket, key)
+}
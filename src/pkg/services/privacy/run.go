@@ -0,0 +1,28 @@
+package privacy
+
+import (
+	"fmt"
+
+	"api.lnlink.net/src/pkg/models/job"
+)
+
+// runs a claimed job to completion and records the outcome on it
+func Run(j *job.Job) error {
+	var err error
+	downloadURL := ""
+
+	switch j.Kind {
+	case job.KindDataExport:
+		downloadURL, err = Export(j)
+	case job.KindAccountDeletion:
+		err = DeleteAccount(j)
+	default:
+		err = fmt.Errorf("unknown job kind %s", j.Kind)
+	}
+
+	if err != nil {
+		j.Fail(err)
+		return err
+	}
+	return j.Complete(downloadURL)
+}
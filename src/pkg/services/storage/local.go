@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Local keeps every bucket as a directory, for development and tests.
+// presigned links point at the API, which checks the signature and serves the file
+type Local struct {
+	dir        string
+	signingKey []byte
+	apiURL     string
+}
+
+var ErrInvalidSignature = errors.New("invalid or expired signature")
+
+func NewLocal(dir string, signingKey string, apiURL string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("can't create storage directory: %v", err)
+	}
+
+	key := []byte(signingKey)
+	if signingKey == "" {
+		log.Println("[Storage] STORAGE_SIGNING_KEY is not set, download links stop working on restart")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("can't generate signing key: %v", err)
+		}
+	}
+
+	return &Local{dir: dir, signingKey: key, apiURL: strings.TrimRight(apiURL, "/")}, nil
+}
+
+// Path is where an object lives on disk, keys can't escape their bucket
+func (l *Local) Path(bucket string, key string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return "", fmt.Errorf("invalid bucket %q", bucket)
+	}
+
+	cleaned := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if cleaned == "" {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(l.dir, bucket, filepath.FromSlash(cleaned)), nil
+}
+
+func (l *Local) Put(ctx context.Context, bucket string, key string, body io.Reader, contentType string) error {
+	target, err := l.Path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("failed to upload %s/%s: %v", bucket, key, err)
+	}
+
+	// written next to the target and renamed, readers never see half a file
+	file, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to upload %s/%s: %v", bucket, key, err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), target)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload %s/%s: %v", bucket, key, err)
+	}
+	return nil
+}
+
+func (l *Local) Get(ctx context.Context, bucket string, key string) ([]byte, error) {
+	body, err := l.Stream(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s/%s: %v", bucket, key, err)
+	}
+	return data, nil
+}
+
+func (l *Local) Stream(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	target, err := l.Path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s/%s: %v", bucket, key, err)
+	}
+	return file, nil
+}
+
+func (l *Local) signature(bucket string, key string, expires int64) string {
+	mac := hmac.New(sha256.New, l.signingKey)
+	fmt.Fprintf(mac, "%s\n%s\n%d", bucket, key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *Local) Presign(ctx context.Context, bucket string, key string, expires time.Duration) (string, error) {
+	if _, err := l.Path(bucket, key); err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(expires).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt, 10))
+	query.Set("signature", l.signature(bucket, key, expiresAt))
+
+	escaped := []string{}
+	for _, part := range strings.Split(key, "/") {
+		escaped = append(escaped, url.PathEscape(part))
+	}
+
+	return fmt.Sprintf("%s/api/storage/%s/%s?%s", l.apiURL, url.PathEscape(bucket), strings.Join(escaped, "/"), query.Encode()), nil
+}
+
+// Verify checks a link made by Presign
+func (l *Local) Verify(bucket string, key string, expires string, signature string) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(l.signature(bucket, key, expiresAt))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// streamed through Put, so the copy appears atomically as well
+func (l *Local) Copy(ctx context.Context, bucket string, from string, to string) error {
+	body, err := l.Stream(ctx, bucket, from)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return l.Put(ctx, bucket, to, body, "")
+}
+
+func (l *Local) Delete(ctx context.Context, bucket string, key string) error {
+	target, err := l.Path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s/%s: %v", bucket, key, err)
+	}
+	return nil
+}
+
+func (l *Local) List(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	root, err := l.Path(bucket, "x")
+	if err != nil {
+		return nil, err
+	}
+	root = filepath.Dir(root)
+
+	// only the directory the prefix points into has to be walked
+	start := root
+	if dir := path.Dir(prefix); dir != "." {
+		start = filepath.Join(root, filepath.FromSlash(path.Clean("/"+dir)))
+	}
+
+	keys := []string{}
+	err = filepath.WalkDir(start, func(current string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+
+		relative, err := filepath.Rel(root, current)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(relative); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s/%s: %v", bucket, prefix, err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"api.lnlink.net/src/pkg/config"
+	"api.lnlink.net/src/pkg/errs"
+	"api.lnlink.net/src/pkg/global"
+)
+
+// Storage keeps objects in buckets, keys are slash separated paths
+type Storage interface {
+	// body should be seekable, S3 needs to know its length up front
+	Put(ctx context.Context, bucket string, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, bucket string, key string) ([]byte, error)
+	// the caller closes the reader
+	Stream(ctx context.Context, bucket string, key string) (io.ReadCloser, error)
+	// a link anyone can download the object from until it expires
+	Presign(ctx context.Context, bucket string, key string, expires time.Duration) (string, error)
+	// copies an object within the bucket without passing it through this process
+	Copy(ctx context.Context, bucket string, from string, to string) error
+	// deleting a missing key is not an error
+	Delete(ctx context.Context, bucket string, key string) error
+	// every key that starts with the prefix, which doesn't have to end at a slash
+	List(ctx context.Context, bucket string, prefix string) ([]string, error)
+}
+
+var ErrNotFound = errors.New("object not found")
+var ErrNotInitialized = errors.New("storage is configured but Init was not called")
+
+var client Storage
+
+// sets up the configured backend, call after global.Init
+func Init() {
+	switch global.CONFIG.Storage.Backend {
+	case config.StorageS3:
+		s3Storage, err := NewS3(global.CONFIG.S3)
+		errs.Invariant(err == nil, "can't configure S3 storage: %v", err)
+		client = s3Storage
+	case config.StorageLocal:
+		localStorage, err := NewLocal(global.CONFIG.Storage.LocalDir, global.CONFIG.Storage.SigningKey, global.CONFIG.URLs.API)
+		errs.Invariant(err == nil, "can't configure local storage: %v", err)
+		client = localStorage
+	}
+}
+
+// the configured backend, nil when storage is disabled
+func Client() Storage {
+	return client
+}
+
+// Active is Client for callers that must not mistake a missing Init for disabled
+// storage, e.g. deletions that would otherwise report files gone that still exist
+func Active() (Storage, error) {
+	if client == nil && global.CONFIG.Storage.Enabled() {
+		return nil, ErrNotInitialized
+	}
+	return client, nil
+}
+
+// removes every object under the prefix
+func DeletePrefix(ctx context.Context, s Storage, bucket string, prefix string) error {
+	keys, err := s.List(ctx, bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := s.Delete(ctx, bucket, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
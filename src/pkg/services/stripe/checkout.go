@@ -1,6 +1,9 @@
 package stripe
 
 import (
+	"fmt"
+	"time"
+
 	"api.lnlink.net/src/pkg/global"
 	"github.com/stripe/stripe-go/v81"
 	"github.com/stripe/stripe-go/v81/checkout/session"
@@ -13,8 +16,8 @@ func CreateCheckoutSession(customerID string, priceID string) (string, error) {
 			{Price: stripe.String(priceID), Quantity: stripe.Int64(1)},
 		},
 		Mode:       stripe.String("payment"),
-		SuccessURL: stripe.String(global.SUCCESS_URL),
-		CancelURL:  stripe.String(global.SUCCESS_URL),
+		SuccessURL: stripe.String(global.CONFIG.Stripe.SuccessURL),
+		CancelURL:  stripe.String(global.CONFIG.Stripe.SuccessURL),
 		TaxIDCollection: &stripe.CheckoutSessionTaxIDCollectionParams{
 			Enabled: stripe.Bool(true),
 		},
@@ -29,3 +32,46 @@ func CreateCheckoutSession(customerID string, priceID string) (string, error) {
 
 	return session.URL, nil
 }
+
+// a completed checkout, what we can show a customer of their purchase history
+type Purchase struct {
+	ID            string    `json:"id"`
+	CreatedAt     time.Time `json:"createdAt"`
+	AmountTotal   int64     `json:"amountTotal"`
+	Currency      string    `json:"currency"`
+	PaymentStatus string    `json:"paymentStatus"`
+	Items         []string  `json:"items"`
+}
+
+func ListPurchases(customerID string) ([]Purchase, error) {
+	params := &stripe.CheckoutSessionListParams{
+		Customer: stripe.String(customerID),
+		Status:   stripe.String(string(stripe.CheckoutSessionStatusComplete)),
+	}
+	params.AddExpand("data.line_items")
+
+	purchases := []Purchase{}
+	iter := session.List(params)
+	for iter.Next() {
+		checkoutSession := iter.CheckoutSession()
+		purchase := Purchase{
+			ID:            checkoutSession.ID,
+			CreatedAt:     time.Unix(checkoutSession.Created, 0),
+			AmountTotal:   checkoutSession.AmountTotal,
+			Currency:      string(checkoutSession.Currency),
+			PaymentStatus: string(checkoutSession.PaymentStatus),
+			Items:         []string{},
+		}
+		if checkoutSession.LineItems != nil {
+			for _, item := range checkoutSession.LineItems.Data {
+				purchase.Items = append(purchase.Items, fmt.Sprintf("%d x %s", item.Quantity, item.Description))
+			}
+		}
+		purchases = append(purchases, purchase)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return purchases, nil
+}
@@ -1,12 +1,18 @@
 package stripe
 
 import (
+	"api.lnlink.net/src/pkg/global"
 	"github.com/stripe/stripe-go/v81"
 	"github.com/stripe/stripe-go/v81/billingportal/session"
 	"github.com/stripe/stripe-go/v81/customer"
 )
 
+// without stripe users get no customer, billing code skips an empty customer id
 func CreateCustomer(email string) (string, error) {
+	if !global.CONFIG.Stripe.Enabled() {
+		return "", nil
+	}
+
 	// search for existing customer by email
 	existing_customer := customer.List(
 		&stripe.CustomerListParams{
@@ -35,6 +41,45 @@ func CreateCustomer(email string) (string, error) {
 	return customer.ID, nil
 }
 
+// organizations always get their own customer, CreateCustomer would
+// reuse the personal customer of whoever created the organization
+func CreateOrganizationCustomer(name string, email string, organizationID string) (string, error) {
+	if !global.CONFIG.Stripe.Enabled() {
+		return "", nil
+	}
+
+	customer, err := customer.New(&stripe.CustomerParams{
+		Name:  stripe.String(name),
+		Email: stripe.String(email),
+		Metadata: map[string]string{
+			"organizationId": organizationID,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return customer.ID, nil
+}
+
+// keeps invoices going to the user's current address
+func UpdateCustomerEmail(customerID string, email string) error {
+	_, err := customer.Update(customerID, &stripe.CustomerParams{
+		Email: stripe.String(email),
+	})
+	return err
+}
+
+// deletes the customer and its payment methods, invoices stay in stripe for bookkeeping
+// a customer that is already gone counts as deleted, so account deletions can be rerun
+func DeleteCustomer(customerID string) error {
+	_, err := customer.Del(customerID, nil)
+	if stripeErr, ok := err.(*stripe.Error); ok && stripeErr.Code == stripe.ErrorCodeResourceMissing {
+		return nil
+	}
+	return err
+}
+
 func GetPortalSession(customerID string) (string, error) {
 	session, err := session.New(&stripe.BillingPortalSessionParams{
 		Customer: stripe.String(customerID),
@@ -0,0 +1,158 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 seed from RFC 6238 appendix B, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func withDigits(t *testing.T, digits int) {
+	previous := DIGITS
+	DIGITS = digits
+	t.Cleanup(func() { DIGITS = previous })
+}
+
+func TestRFC6238Vectors(t *testing.T) {
+	withDigits(t, 8)
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.code, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, test.code, time.Unix(test.unix, 0))
+			if !ok {
+				t.Fatalf("code %s not accepted at %d", test.code, test.unix)
+			}
+			if want := test.unix / 30; step != want {
+				t.Fatalf("step = %d, want %d", step, want)
+			}
+		})
+	}
+}
+
+func TestSkewWindow(t *testing.T) {
+	withDigits(t, 8)
+
+	// 94287082 is the code for step 1 (t = 30..59)
+	tests := []struct {
+		name string
+		unix int64
+		ok   bool
+	}{
+		{"one period early", 0, true},
+		{"on time", 45, true},
+		{"one period late", 60 + 29, true},
+		{"two periods late", 90, false},
+		{"far off", 1111111109, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, "94287082", time.Unix(test.unix, 0))
+			if ok != test.ok {
+				t.Fatalf("Validate at %d = %v, want %v", test.unix, ok, test.ok)
+			}
+			// the matched step is the code's, not the current one, so callers can track reuse
+			if ok && step != 1 {
+				t.Fatalf("step = %d, want 1", step)
+			}
+		})
+	}
+}
+
+func TestNoSkew(t *testing.T) {
+	withDigits(t, 8)
+	previous := SKEW
+	SKEW = 0
+	t.Cleanup(func() { SKEW = previous })
+
+	if _, ok := Validate(rfcSecret, "94287082", time.Unix(60, 0)); ok {
+		t.Fatal("code from the previous period accepted without skew")
+	}
+	if _, ok := Validate(rfcSecret, "94287082", time.Unix(59, 0)); !ok {
+		t.Fatal("code rejected in its own period")
+	}
+}
+
+// replays are rejected by the caller storing the last used step, which only
+// works if reusing a code always reports the step it was issued for
+func TestReusedCodeMatchesSameStep(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, ok := Validate(secret, code, now)
+	if !ok {
+		t.Fatal("fresh code rejected")
+	}
+	second, ok := Validate(secret, code, now.Add(PERIOD))
+	if !ok {
+		t.Fatal("code rejected within the skew window")
+	}
+	if first != second {
+		t.Fatalf("the same code matched steps %d and %d", first, second)
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := encoding.DecodeString(secret)
+	now := time.Unix(1700000000, 0)
+	code := codeAt(key, now.Unix()/30)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+	}{
+		{"plain", secret, code, true},
+		{"spaced", secret, code[:3] + " " + code[3:] + " ", true},
+		{"lowercase secret", strings.ToLower(secret), code, true},
+		{"too short", secret, code[:5], false},
+		{"too long", secret, code + "0", false},
+		{"invalid secret", "not base32!", code, false},
+		{"empty", secret, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, ok := Validate(test.secret, test.code, now); ok != test.ok {
+				t.Fatalf("Validate = %v, want %v", ok, test.ok)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil || len(key) != SECRET_SIZE {
+		t.Fatalf("secret %s decodes to %d bytes, %v", secret, len(key), err)
+	}
+}